@@ -1,5 +1,38 @@
 package main
 
+import (
+	"net/http"
+	"time"
+
+	"github.com/donetkit/contrib-gin/middleware/cache"
+	"github.com/donetkit/contrib-log/glog"
+	redisRedis "github.com/donetkit/contrib/db/redis"
+	"github.com/gin-gonic/gin"
+)
+
 func main() {
+	log := glog.New()
+	rdb := redisRedis.New(redisRedis.WithLogger(log), redisRedis.WithAddr("127.0.0.1"), redisRedis.WithPassword("test"), redisRedis.WithDB(1))
+	pages := cache.New(
+		cache.WithCache(rdb),
+		cache.WithDB(1),
+		cache.WithLogger(log),
+		cache.WithExpire(time.Minute),
+		cache.WithKeyFunc(cache.KeyWithSortedQuery("utm_")),
+		cache.WithRouteExpire("/products/:id", 10*time.Minute),
+		cache.WithPurgeIndex(),
+		cache.WithCoalescing(),
+		cache.WithStaleWhileRevalidate(30*time.Second),
+	)
 
+	r := gin.Default()
+	r.GET("/products/:id", pages.Page(func(c *gin.Context) {
+		cache.Tag(c, "product:"+c.Param("id"))
+		c.String(http.StatusOK, "product %s at %s", c.Param("id"), time.Now())
+	}))
+	r.POST("/admin/products/:id", func(c *gin.Context) {
+		n := pages.PurgeTag(c.Request.Context(), "product:"+c.Param("id"))
+		c.JSON(http.StatusOK, gin.H{"purged": n})
+	})
+	r.Run(":8000")
 }
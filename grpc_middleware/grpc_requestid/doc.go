@@ -0,0 +1,9 @@
+//`grpc_requestid` are interceptors that propagate the request id of the requestid middleware.
+//Client Side Request ID Middleware
+//The request id of the call context is sent in the metadata of the outgoing calls.
+//Server Side Request ID Middleware
+//The request id is extracted from the incoming metadata, or generated when it is missing, too long
+//or made of disallowed characters (see WithMaxLength and WithCharset),
+//and made available with requestid.FromContext.
+
+package grpc_requestid
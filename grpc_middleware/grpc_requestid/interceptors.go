@@ -0,0 +1,71 @@
+package grpc_requestid
+
+import (
+	"context"
+
+	"github.com/donetkit/contrib-gin/grpc_middleware"
+	"github.com/donetkit/contrib-gin/middleware/requestid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// UnaryClientInterceptor returns a new unary client interceptor sending the request id of the call context.
+func UnaryClientInterceptor(opts ...Option) grpc.UnaryClientInterceptor {
+	o := evaluateOptions(opts)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		return invoker(outgoing(ctx, o), method, req, reply, cc, callOpts...)
+	}
+}
+
+// StreamClientInterceptor returns a new streaming client interceptor sending the request id of the call context.
+func StreamClientInterceptor(opts ...Option) grpc.StreamClientInterceptor {
+	o := evaluateOptions(opts)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoing(ctx, o), desc, cc, method, callOpts...)
+	}
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor putting the request id in the context.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	o := evaluateOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		rid := incoming(ctx, o)
+		grpc.SetHeader(ctx, metadata.Pairs(o.metadataKey, rid))
+		return handler(requestid.NewContext(ctx, rid), req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor putting the request id in the context.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	o := evaluateOptions(opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		rid := incoming(stream.Context(), o)
+		stream.SetHeader(metadata.Pairs(o.metadataKey, rid))
+		wrapped := grpc_middleware.WrapServerStream(stream)
+		wrapped.WrappedContext = requestid.NewContext(stream.Context(), rid)
+		return handler(srv, wrapped)
+	}
+}
+
+// outgoing adds the request id of ctx to the outgoing metadata, unless it is already set.
+func outgoing(ctx context.Context, o *options) context.Context {
+	rid := requestid.FromContext(ctx)
+	if rid == "" {
+		return ctx
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(o.metadataKey)) > 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, o.metadataKey, rid)
+}
+
+// incoming returns the request id of the incoming metadata, or a new one when it is missing or invalid,
+// like the requestid middleware does for HTTP requests.
+func incoming(ctx context.Context, o *options) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(o.metadataKey); len(values) > 0 && values[0] != "" && requestid.Valid(values[0], o.maxLength, o.charset) {
+			return values[0]
+		}
+	}
+	return o.generator()
+}
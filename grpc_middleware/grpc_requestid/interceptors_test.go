@@ -0,0 +1,120 @@
+package grpc_requestid
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/donetkit/contrib-gin/middleware/requestid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const testRequestID = "test-request-id"
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	var md metadata.MD
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+	interceptor := UnaryClientInterceptor()
+
+	ctx := requestid.NewContext(context.Background(), testRequestID)
+	assert.NoError(t, interceptor(ctx, "FakeMethod", nil, nil, nil, invoker))
+	assert.Equal(t, []string{testRequestID}, md.Get(DefaultMetadataKey))
+
+	ctx = metadata.AppendToOutgoingContext(ctx, DefaultMetadataKey, "explicit")
+	assert.NoError(t, interceptor(ctx, "FakeMethod", nil, nil, nil, invoker))
+	assert.Equal(t, []string{"explicit"}, md.Get(DefaultMetadataKey))
+
+	md = nil
+	assert.NoError(t, interceptor(context.Background(), "FakeMethod", nil, nil, nil, invoker))
+	assert.Empty(t, md.Get(DefaultMetadataKey))
+}
+
+func TestStreamClientInterceptor(t *testing.T) {
+	var md metadata.MD
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		md, _ = metadata.FromOutgoingContext(ctx)
+		return nil, nil
+	}
+	ctx := requestid.NewContext(context.Background(), testRequestID)
+	_, err := StreamClientInterceptor(WithMetadataKey("rid"))(ctx, &grpc.StreamDesc{}, nil, "FakeMethod", streamer)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{testRequestID}, md.Get("rid"))
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	var rid string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		rid = requestid.FromContext(ctx)
+		return nil, nil
+	}
+	interceptor := UnaryServerInterceptor(WithGenerator(func() string { return "generated" }))
+	info := &grpc.UnaryServerInfo{FullMethod: "FakeMethod"}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(DefaultMetadataKey, testRequestID))
+	interceptor(ctx, nil, info, handler)
+	assert.Equal(t, testRequestID, rid)
+
+	interceptor(context.Background(), nil, info, handler)
+	assert.Equal(t, "generated", rid)
+}
+
+func TestInvalidIncomingRequestID(t *testing.T) {
+	var rid string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		rid = requestid.FromContext(ctx)
+		return nil, nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "FakeMethod"}
+	call := func(interceptor grpc.UnaryServerInterceptor, incoming string) string {
+		rid = ""
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(DefaultMetadataKey, incoming))
+		interceptor(ctx, nil, info, handler)
+		return rid
+	}
+
+	interceptor := UnaryServerInterceptor(WithGenerator(func() string { return "generated" }))
+	assert.Equal(t, "generated", call(interceptor, strings.Repeat("a", requestid.DefaultMaxLength+1)))
+	assert.Equal(t, "generated", call(interceptor, "id with spaces"))
+	assert.Equal(t, "generated", call(interceptor, "id\nforged log line"))
+	assert.Equal(t, strings.Repeat("a", requestid.DefaultMaxLength), call(interceptor, strings.Repeat("a", requestid.DefaultMaxLength)))
+
+	interceptor = UnaryServerInterceptor(WithGenerator(func() string { return "generated" }), WithMaxLength(8), WithCharset(regexp.MustCompile(`^[a-z0-9-]+$`)))
+	assert.Equal(t, "generated", call(interceptor, "abc-123-xyz"))
+	assert.Equal(t, "generated", call(interceptor, "ABC"))
+	assert.Equal(t, "abc-123", call(interceptor, "abc-123"))
+
+	interceptor = UnaryServerInterceptor(WithGenerator(func() string { return "generated" }), WithCharset(regexp.MustCompile(`[a-z0-9-]+`)))
+	assert.Equal(t, "generated", call(interceptor, "abc\r\nlevel=error"))
+	assert.Equal(t, "generated", call(interceptor, "abc!"))
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	header metadata.MD
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
+
+func (s *fakeServerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	var rid string
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		rid = requestid.FromContext(stream.Context())
+		return nil
+	}
+	stream := &fakeServerStream{ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs(DefaultMetadataKey, testRequestID))}
+	assert.NoError(t, StreamServerInterceptor()(nil, stream, &grpc.StreamServerInfo{FullMethod: "FakeMethod"}, handler))
+	assert.Equal(t, testRequestID, rid)
+	assert.Equal(t, []string{testRequestID}, stream.header.Get(DefaultMetadataKey))
+}
@@ -0,0 +1,65 @@
+package grpc_requestid
+
+import (
+	"regexp"
+
+	"github.com/donetkit/contrib-gin/middleware/requestid"
+	"github.com/donetkit/contrib/utils/uuid"
+)
+
+// DefaultMetadataKey is the metadata key of the request id.
+const DefaultMetadataKey = "x-request-id"
+
+type options struct {
+	metadataKey string
+	generator   func() string
+	maxLength   int
+	charset     *regexp.Regexp
+}
+
+func evaluateOptions(opts []Option) *options {
+	o := &options{
+		metadataKey: DefaultMetadataKey,
+		generator: func() string {
+			return uuid.NewUUID()
+		},
+		maxLength: requestid.DefaultMaxLength,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+type Option func(*options)
+
+// WithMetadataKey customizes the metadata key of the request id.
+func WithMetadataKey(key string) Option {
+	return func(o *options) {
+		o.metadataKey = key
+	}
+}
+
+// WithGenerator customizes the function generating the request id of the calls received without one.
+func WithGenerator(generator func() string) Option {
+	return func(o *options) {
+		o.generator = generator
+	}
+}
+
+// WithMaxLength set the max length of the incoming ids, longer ids are replaced by a generated one.
+// Default requestid.DefaultMaxLength, 0 disables the check.
+func WithMaxLength(n int) Option {
+	return func(o *options) {
+		o.maxLength = n
+	}
+}
+
+// WithCharset set the pattern the incoming ids must match as a whole, e.g. `^[A-Za-z0-9-]+$`, others
+// are replaced by a generated one. Any printable ASCII character except the space is allowed by default,
+// others never are.
+func WithCharset(charset *regexp.Regexp) Option {
+	return func(o *options) {
+		o.charset = charset
+	}
+}
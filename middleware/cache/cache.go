@@ -2,6 +2,7 @@ package cache
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"errors"
 	"github.com/donetkit/contrib/utils/cache"
@@ -23,123 +24,409 @@ type responseCache struct {
 	status int
 	header http.Header
 	data   []byte
+	stored time.Time
+	// expires is the end of the fresh lifetime, the entry may be served stale for
+	// staleWhileRevalidate or staleIfError after it.
+	expires              time.Time
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+	// vary is only set on the entry stored under the page key when the response
+	// has a Vary header, the response itself is stored under the variant key.
+	vary []string
 }
 
+func (r responseCache) fresh(now time.Time) bool {
+	return r.expires.IsZero() || now.Before(r.expires)
+}
+
+func (r responseCache) revalidatable(now time.Time) bool {
+	return now.Before(r.expires.Add(r.staleWhileRevalidate))
+}
+
+func (r responseCache) usableOnError(now time.Time) bool {
+	return now.Before(r.expires.Add(r.staleIfError))
+}
+
+// cachedWriter passes the response through to the client and buffers a copy of it,
+// the copy is stored once by commit after the handler chain has finished.
+// While hold is set nothing reaches the client, so a stale copy can still be served instead
+// and the validators can be added to the header before it is sent.
 type cachedWriter struct {
 	c *gin.Context
 	gin.ResponseWriter
-	status  int
-	written bool
-	store   cache.ICache
-	expire  time.Duration
-	key     string
+	expire   time.Duration
+	key      string
+	opt      *option
+	body     bytes.Buffer
+	overflow bool
+	hold     bool
 }
 
-func SiteCache(store cache.ICache, expire time.Duration) gin.HandlerFunc {
+// PageCache caches whole responses in a cache.ICache, see New.
+type PageCache struct {
+	opt *option
+}
+
+// New returns a PageCache configured by opts, WithCache is required.
+func New(opts ...Option) *PageCache {
+	return &PageCache{opt: newOption(opts...)}
+}
+
+// Site returns a middleware caching the responses of every route after it.
+func (p *PageCache) Site() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		url := c.Request.URL
-		key := urlEscape(PageCachePrefix, url.RequestURI())
-		var cacheData = store.WithDB(0).WithContext(c.Request.Context()).Get(key)
-		if cacheData == nil {
-			c.Next()
-			return
-		}
-		cache, ok := cacheData.(responseCache)
-		if !ok {
-			c.Next()
-		}
-		c.Writer.WriteHeader(cache.status)
-		for k, val := range cache.header {
-			for _, v := range val {
-				c.Writer.Header().Add(k, v)
-			}
+		if servePage(c, p.opt, nil) {
+			c.Abort()
 		}
-		c.Writer.Write(cache.data)
 	}
 }
 
-func CachePage(store cache.ICache, expire time.Duration, handle gin.HandlerFunc) gin.HandlerFunc {
+// Page returns a handler caching the responses of handle, opts override the options of p for this route only.
+func (p *PageCache) Page(handle gin.HandlerFunc, opts ...Option) gin.HandlerFunc {
+	opt := p.opt
+	if len(opts) > 0 {
+		opt = p.opt.with(opts...)
+	}
 	return func(c *gin.Context) {
-		url := c.Request.URL
-		key := urlEscape(PageCachePrefix, url.RequestURI())
-		cacheData := store.WithDB(0).WithContext(c.Request.Context()).Get(key)
-		if cacheData == nil { // replace writer
-			writer := newCachedWriter(c, store, expire, c.Writer, key)
-			c.Writer = writer
-			handle(c)
+		servePage(c, opt, handle)
+	}
+}
+
+// PurgeURL evicts the page stored for the request URI, all of its Vary variants included.
+// Pages stored under a custom KeyFunc are only found through the index, see WithPurgeIndex.
+// It purges the db of p and returns the number of removed entries.
+func (p *PageCache) PurgeURL(ctx context.Context, uri string) int64 {
+	return purgeURL(p.opt.store(ctx), uri)
+}
+
+// PurgePrefix evicts every indexed page whose request path starts with prefix.
+// It purges the db of p and returns the number of removed entries.
+func (p *PageCache) PurgePrefix(ctx context.Context, prefix string) int64 {
+	return purgePrefix(p.opt.store(ctx), prefix)
+}
+
+// PurgeTag evicts every page tagged with one of tags.
+// It purges the db of p and returns the number of removed entries.
+func (p *PageCache) PurgeTag(ctx context.Context, tags ...string) int64 {
+	return purgeTag(p.opt.store(ctx), tags...)
+}
+
+func SiteCache(store cache.ICache, expire time.Duration, opts ...Option) gin.HandlerFunc {
+	return New(append([]Option{WithCache(store), WithExpire(expire)}, opts...)...).Site()
+}
+
+func CachePage(store cache.ICache, expire time.Duration, handle gin.HandlerFunc, opts ...Option) gin.HandlerFunc {
+	return New(append([]Option{WithCache(store), WithExpire(expire)}, opts...)...).Page(handle)
+}
+
+func NewPageCache(store cache.ICache, expire time.Duration, handle gin.HandlerFunc, opts ...Option) gin.HandlerFunc {
+	return New(append([]Option{WithCache(store), WithExpire(expire)}, opts...)...).Page(handle)
+}
+
+// servePage writes the cached response for the request, or runs handle and stores its response.
+// A nil handle runs the rest of the chain, which can not be refreshed in the background.
+// It returns true when the response was served from the cache.
+func servePage(c *gin.Context, opt *option, handle gin.HandlerFunc) bool {
+	background := handle != nil
+	if handle == nil {
+		handle = func(c *gin.Context) { c.Next() }
+	}
+	key := urlEscape(PageCachePrefix, opt.keyFunc(c))
+	if opt.httpSemantics && c.Request.Method != http.MethodGet {
+		return bypassPage(c, key, opt, handle)
+	}
+	if opt.httpSemantics && requestNoCache(c.Request) {
+		generatePage(c, key, opt, handle, nil)
+		return false
+	}
+	now := time.Now()
+	cache, found := lookupResponseCache(c, key, opt)
+	if found && cache.fresh(now) {
+		writeResponseCache(c, cache, opt)
+		return true
+	}
+	if found && cache.revalidatable(now) {
+		// the other requests get the stale copy while one of them refreshes the page
+		f, leader := opt.flights.join(key)
+		switch {
+		case !leader:
+		case background:
+			refreshPage(c, key, opt, handle, f)
+		default:
+			return leadPage(c, key, opt, handle, &cache, f)
 		}
-		cache, ok := cacheData.(responseCache) // replace writer
-		if ok {
-			c.Writer.WriteHeader(cache.status)
-			for k, vals := range cache.header {
-				for _, v := range vals {
-					c.Writer.Header().Add(k, v)
-				}
-			}
-			c.Writer.Write(cache.data)
+		writeResponseCache(c, cache, opt)
+		return true
+	}
+	if !opt.coalescing {
+		var stale *responseCache
+		if found && cache.usableOnError(now) {
+			stale = &cache
 		}
+		_, _, hit := generatePage(c, key, opt, handle, stale)
+		return hit
+	}
+	var stale *responseCache
+	if found && cache.usableOnError(now) {
+		stale = &cache
+	}
+	f, leader := opt.flights.join(key)
+	if leader {
+		return leadPage(c, key, opt, handle, stale, f)
+	}
+	if !f.wait(opt.refreshTimeout) {
+		// the leader is stuck, don't wait for it any longer
+		_, _, hit := generatePage(c, key, opt, handle, stale)
+		return hit
 	}
+	if f.ok && len(varyHeaders(f.cache.header)) == 0 {
+		writeResponseCache(c, f.cache, opt)
+		return true
+	}
+	if cache, found = lookupResponseCache(c, key, opt); found {
+		writeResponseCache(c, cache, opt)
+		return true
+	}
+	generatePage(c, key, opt, handle, nil)
+	return false
 }
 
-func NewPageCache(store cache.ICache, expire time.Duration, handle gin.HandlerFunc) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		url := c.Request.URL
-		key := urlEscape(PageCachePrefix, url.RequestURI())
-		cacheData := store.WithDB(0).WithContext(c.Request.Context()).Get(key)
-		if cacheData == nil {
-			writer := newCachedWriter(c, store, expire, c.Writer, key) // replace writer
-			c.Writer = writer
-			handle(c)
-			return
+// bypassPage serves a request which is not GET in http semantics mode, only GET responses are stored.
+// HEAD is answered from a fresh stored page, unsafe methods invalidate it unless they fail, see RFC 9111 section 4.4.
+func bypassPage(c *gin.Context, key string, opt *option, handle gin.HandlerFunc) bool {
+	switch c.Request.Method {
+	case http.MethodHead:
+		if cache, found := lookupResponseCache(c, key, opt); found && cache.fresh(time.Now()) {
+			writeResponseCache(c, cache, opt)
+			return true
 		}
-		cache, ok := cacheData.(responseCache)
-		if ok {
-			c.Writer.WriteHeader(cache.status)
-			for k, vals := range cache.header {
-				for _, v := range vals {
-					c.Writer.Header().Add(k, v)
-				}
-			}
-			c.Writer.Write(cache.data)
+		handle(c)
+	case http.MethodOptions, http.MethodTrace:
+		handle(c)
+	default:
+		handle(c)
+		if c.Writer.Status() < http.StatusBadRequest {
+			opt.store(c.Request.Context()).Delete(key)
 		}
+	}
+	return false
+}
 
+// leadPage regenerates the page as the leader of flight f and hands the result to its waiters.
+func leadPage(c *gin.Context, key string, opt *option, handle gin.HandlerFunc, stale *responseCache, f *flight) (hit bool) {
+	var (
+		cache responseCache
+		ok    bool
+	)
+	defer func() {
+		opt.flights.done(key, f, cache, ok)
+	}()
+	if stale != nil && !stale.usableOnError(time.Now()) {
+		stale = nil
 	}
+	cache, ok, hit = generatePage(c, key, opt, handle, stale)
+	return hit
 }
 
-func newCachedWriter(c *gin.Context, store cache.ICache, expire time.Duration, writer gin.ResponseWriter, key string) *cachedWriter {
-	return &cachedWriter{c, writer, 0, false, store, expire, key}
+// generatePage runs handle and stores its response. When stale is given the response is held
+// back and stale is served instead if the handler fails with a server error. In http semantics
+// mode it is held back until the validators are added.
+func generatePage(c *gin.Context, key string, opt *option, handle gin.HandlerFunc, stale *responseCache) (responseCache, bool, bool) {
+	writer := newCachedWriter(c, opt.expireFor(c), c.Writer, key, opt) // replace writer
+	writer.hold = stale != nil || opt.httpSemantics
+	c.Writer = writer
+	handle(c)
+	if writer.hold {
+		if stale != nil && writer.Status() >= http.StatusInternalServerError {
+			writer.discard()
+			c.Writer = writer.ResponseWriter
+			writeResponseCache(c, *stale, opt)
+			return *stale, true, true
+		}
+		writer.validate()
+		writer.release()
+	}
+	cache, ok := writer.commit()
+	return cache, ok, false
 }
 
-func (w *cachedWriter) WriteHeader(code int) {
-	w.status = code
-	w.written = true
-	w.ResponseWriter.WriteHeader(code)
+// lookupResponseCache returns the cached response for key, following the Vary entry in http semantics mode.
+func lookupResponseCache(c *gin.Context, key string, opt *option) (responseCache, bool) {
+	cache, err := decodeResponseCache(opt.store(c.Request.Context()).Get(key))
+	if err != nil || !opt.httpSemantics || len(cache.vary) == 0 {
+		return cache, err == nil
+	}
+	key = varyKey(key, cache.vary, c.Request)
+	cache, err = decodeResponseCache(opt.store(c.Request.Context()).Get(key))
+	return cache, err == nil && len(cache.vary) == 0
 }
 
-func (w *cachedWriter) Status() int {
-	return w.status
+func writeResponseCache(c *gin.Context, cache responseCache, opt *option) {
+	if opt.httpSemantics {
+		if notModified(c.Request, cache.header) {
+			writeNotModified(c, cache)
+			return
+		}
+		c.Writer.Header().Set("Age", age(cache.stored))
+	}
+	for k, vals := range cache.header {
+		for _, v := range vals {
+			c.Writer.Header().Add(k, v)
+		}
+	}
+	c.Writer.WriteHeader(cache.status)
+	c.Writer.Write(cache.data)
 }
 
-func (w *cachedWriter) Written() bool {
-	return w.written
+func newCachedWriter(c *gin.Context, expire time.Duration, writer gin.ResponseWriter, key string, opt *option) *cachedWriter {
+	return &cachedWriter{c: c, ResponseWriter: writer, expire: expire, key: key, opt: opt}
 }
 
 func (w *cachedWriter) Write(data []byte) (int, error) {
+	if w.hold {
+		if w.opt.maxBodySize <= 0 || w.body.Len()+len(data) <= w.opt.maxBodySize {
+			return w.body.Write(data)
+		}
+		// too large to be stored, so there is no point in holding it back
+		if err := w.release(); err != nil {
+			return 0, err
+		}
+	}
 	ret, err := w.ResponseWriter.Write(data)
 	if err == nil {
-		//cache response
-		store := w.store
-		val := responseCache{
-			w.status,
-			w.Header(),
-			data,
+		w.buffer(data[:ret])
+	}
+	return ret, err
+}
+
+func (w *cachedWriter) WriteString(s string) (int, error) {
+	if w.hold {
+		return w.Write([]byte(s))
+	}
+	ret, err := w.ResponseWriter.WriteString(s)
+	if err == nil {
+		w.buffer([]byte(s[:ret]))
+	}
+	return ret, err
+}
+
+func (w *cachedWriter) WriteHeaderNow() {
+	if w.hold {
+		return
+	}
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *cachedWriter) Flush() {
+	if w.hold {
+		return
+	}
+	w.ResponseWriter.Flush()
+}
+
+// release stops holding the response back and writes the held body to the client.
+func (w *cachedWriter) release() error {
+	w.hold = false
+	if w.body.Len() == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(w.body.Bytes())
+	return err
+}
+
+// discard drops the held response, its headers included.
+func (w *cachedWriter) discard() {
+	w.hold = false
+	w.overflow = true
+	w.body = bytes.Buffer{}
+	header := w.Header()
+	for k := range header {
+		delete(header, k)
+	}
+}
+
+// buffer keeps a copy of data until the body grows over the size limit.
+func (w *cachedWriter) buffer(data []byte) {
+	if w.overflow {
+		return
+	}
+	if w.opt.maxBodySize > 0 && w.body.Len()+len(data) > w.opt.maxBodySize {
+		w.overflow = true
+		w.body = bytes.Buffer{}
+		return
+	}
+	w.body.Write(data)
+}
+
+// validate sets ETag and Last-Modified on the header of a response that is going to be stored,
+// in http semantics mode. The header must not have been sent yet.
+func (w *cachedWriter) validate() {
+	if !w.opt.httpSemantics || w.overflow || !w.opt.isCacheableStatus(w.Status()) {
+		return
+	}
+	if _, ok := storableExpire(w.c.Request, w.Header(), w.expire); ok {
+		addValidators(w.Header(), w.body.Bytes(), time.Now())
+	}
+}
+
+// commit stores the buffered response when it is complete and its status is cacheable.
+// It returns the stored response and whether it was stored.
+func (w *cachedWriter) commit() (responseCache, bool) {
+	if w.overflow || !w.opt.isCacheableStatus(w.Status()) {
+		return responseCache{}, false
+	}
+	val := responseCache{
+		status:               w.Status(),
+		header:               w.Header().Clone(),
+		data:                 w.body.Bytes(),
+		stored:               time.Now(),
+		staleWhileRevalidate: w.opt.staleWhileRevalidate,
+		staleIfError:         w.opt.staleIfError,
+	}
+	key, expire := w.key, w.expire
+	if w.opt.httpSemantics {
+		var ok bool
+		if expire, ok = storableExpire(w.c.Request, val.header, expire); !ok {
+			return responseCache{}, false
 		}
-		err = store.WithDB(0).WithContext(w.c.Request.Context()).Set(w.key, val, w.expire)
-		if err != nil {
-			// need logger
+		val.staleWhileRevalidate, val.staleIfError = staleWindows(val.header, val.staleWhileRevalidate, val.staleIfError)
+	}
+	if expire > 0 {
+		val.expires = val.stored.Add(expire)
+	}
+	ttl := expire + maxDuration(val.staleWhileRevalidate, val.staleIfError)
+	if w.opt.httpSemantics {
+		if vary := varyHeaders(val.header); len(vary) > 0 {
+			if vary[0] == "*" {
+				return responseCache{}, false
+			}
+			w.set(key, responseCache{vary: vary, stored: val.stored, expires: val.expires}, ttl)
+			key = varyKey(key, vary, w.c.Request)
 		}
 	}
-	return ret, err
+	w.set(key, val, ttl)
+	return val, true
+}
+
+func (w *cachedWriter) set(key string, val responseCache, expire time.Duration) {
+	data, err := encodeResponseCache(val, w.opt.gzipMinSize)
+	if err == nil {
+		err = w.opt.store(w.c.Request.Context()).Set(key, data, expire)
+	}
+	if err != nil {
+		if w.opt.logger != nil {
+			w.opt.logger.Errorf("set page cache %s: %s", key, err)
+		}
+		return
+	}
+	w.index(key, expire)
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
 }
 
 func urlEscape(prefix string, u string) string {
@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// notModifiedHeaders are the stored headers repeated in a 304 response, see RFC 9110 section 15.4.5.
+var notModifiedHeaders = []string{"Cache-Control", "Content-Location", "Date", "ETag", "Expires", "Vary", "Last-Modified"}
+
+// cacheControl holds the directives of a Cache-Control header, directive names are lower case.
+type cacheControl map[string]string
+
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, line := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(line, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, value, _ := strings.Cut(part, "=")
+			cc[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// seconds returns the delta-seconds value of directive.
+func (cc cacheControl) seconds(directive string) (time.Duration, bool) {
+	value, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// requestNoCache reports whether the client asked for a response that is not served from the cache.
+func requestNoCache(r *http.Request) bool {
+	cc := parseCacheControl(r.Header)
+	if cc.has("no-cache") {
+		return true
+	}
+	if maxAge, ok := cc.seconds("max-age"); ok && maxAge == 0 {
+		return true
+	}
+	return len(cc) == 0 && strings.EqualFold(r.Header.Get("Pragma"), "no-cache")
+}
+
+// storableExpire returns how long the response may be stored, s-maxage and max-age take
+// precedence over expire. It returns false when the request or the response forbids storing.
+func storableExpire(r *http.Request, header http.Header, expire time.Duration) (time.Duration, bool) {
+	if parseCacheControl(r.Header).has("no-store") {
+		return 0, false
+	}
+	cc := parseCacheControl(header)
+	if cc.has("no-store") || cc.has("private") || cc.has("no-cache") {
+		return 0, false
+	}
+	if maxAge, ok := cc.seconds("s-maxage"); ok {
+		expire = maxAge
+	} else if maxAge, ok = cc.seconds("max-age"); ok {
+		expire = maxAge
+	}
+	return expire, expire > 0
+}
+
+// staleWindows returns the stale-while-revalidate and stale-if-error directives of the response,
+// falling back to the configured windows, see RFC 5861.
+func staleWindows(header http.Header, staleWhileRevalidate, staleIfError time.Duration) (time.Duration, time.Duration) {
+	cc := parseCacheControl(header)
+	if d, ok := cc.seconds("stale-while-revalidate"); ok {
+		staleWhileRevalidate = d
+	}
+	if d, ok := cc.seconds("stale-if-error"); ok {
+		staleIfError = d
+	}
+	return staleWhileRevalidate, staleIfError
+}
+
+// addValidators sets ETag and Last-Modified on the header when the handler did not.
+func addValidators(header http.Header, data []byte, stored time.Time) {
+	if header.Get("ETag") == "" {
+		sum := sha1.Sum(data)
+		header.Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	}
+	if header.Get("Last-Modified") == "" {
+		header.Set("Last-Modified", stored.UTC().Format(http.TimeFormat))
+	}
+}
+
+// varyHeaders returns the canonical, sorted names listed in the Vary header, or "*" alone.
+func varyHeaders(header http.Header) []string {
+	var names []string
+	for _, line := range header.Values("Vary") {
+		for _, name := range strings.Split(line, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return []string{"*"}
+			}
+			if name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// varyKey derives the variant key of a page from the request values of the vary headers.
+func varyKey(key string, vary []string, r *http.Request) string {
+	h := sha1.New()
+	for _, name := range vary {
+		h.Write([]byte(name))
+		h.Write([]byte{':'})
+		h.Write([]byte(strings.Join(r.Header.Values(name), ",")))
+		h.Write([]byte{'\n'})
+	}
+	return key + ":vary:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since when there is none, against the stored header.
+func notModified(r *http.Request, header http.Header) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := header.Get("ETag")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return !lastModified.After(ims)
+}
+
+func writeNotModified(c *gin.Context, cache responseCache) {
+	for _, k := range notModifiedHeaders {
+		if v := cache.header.Values(k); len(v) > 0 {
+			c.Writer.Header()[http.CanonicalHeaderKey(k)] = v
+		}
+	}
+	c.Writer.Header().Set("Age", age(cache.stored))
+	c.Writer.WriteHeader(http.StatusNotModified)
+	c.Writer.WriteHeaderNow()
+}
+
+func age(stored time.Time) string {
+	if stored.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(int64(time.Since(stored)/time.Second), 10)
+}
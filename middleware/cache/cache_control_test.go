@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPSemanticsVary(t *testing.T) {
+	store := newMemoryStore()
+	calls := 0
+	r := gin.New()
+	r.GET("/lang", CachePage(store, time.Minute, func(c *gin.Context) {
+		calls++
+		c.Header("Vary", "Accept-Language")
+		c.String(http.StatusOK, c.GetHeader("Accept-Language"))
+	}, WithHTTPSemantics()))
+
+	for _, lang := range []string{"en", "de", "en", "de"} {
+		req := httptest.NewRequest(http.MethodGet, "/lang", nil)
+		req.Header.Set("Accept-Language", lang)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, lang, w.Body.String())
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestHTTPSemanticsPrivate(t *testing.T) {
+	store := newMemoryStore()
+	calls := 0
+	r := gin.New()
+	r.GET("/private", CachePage(store, time.Minute, func(c *gin.Context) {
+		calls++
+		c.Header("Cache-Control", "private, max-age=60")
+		c.String(http.StatusOK, "private")
+	}, WithHTTPSemantics()))
+
+	performRequest(r, http.MethodGet, "/private")
+	performRequest(r, http.MethodGet, "/private")
+
+	assert.Equal(t, 2, calls)
+}
+
+func TestHTTPSemanticsMaxAge(t *testing.T) {
+	store := newMemoryStore()
+	r := gin.New()
+	r.GET("/max-age", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=10, s-maxage=30")
+		c.String(http.StatusOK, "max-age")
+	}, WithHTTPSemantics()))
+
+	performRequest(r, http.MethodGet, "/max-age")
+
+	assert.Equal(t, 30*time.Second, store.expires[urlEscape(PageCachePrefix, "/max-age")])
+}
+
+func TestHTTPSemanticsRequestNoCache(t *testing.T) {
+	store := newMemoryStore()
+	calls := 0
+	r := gin.New()
+	r.GET("/no-cache", CachePage(store, time.Minute, func(c *gin.Context) {
+		calls++
+		c.String(http.StatusOK, "no-cache")
+	}, WithHTTPSemantics()))
+
+	performRequest(r, http.MethodGet, "/no-cache")
+	req := httptest.NewRequest(http.MethodGet, "/no-cache", nil)
+	req.Header.Set("Cache-Control", "no-cache")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, 2, calls)
+}
+
+func TestHTTPSemanticsConditional(t *testing.T) {
+	store := newMemoryStore()
+	r := gin.New()
+	r.GET("/etag", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.String(http.StatusOK, "etag")
+	}, WithHTTPSemantics()))
+
+	performRequest(r, http.MethodGet, "/etag")
+	w := performRequest(r, http.MethodGet, "/etag")
+	etag := w.Header().Get("ETag")
+	lastModified := w.Header().Get("Last-Modified")
+	assert.NotEmpty(t, etag)
+	assert.NotEmpty(t, lastModified)
+
+	req := httptest.NewRequest(http.MethodGet, "/etag", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, "", w.Body.String())
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+
+	req = httptest.NewRequest(http.MethodGet, "/etag", nil)
+	req.Header.Set("If-Modified-Since", lastModified)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/etag", nil)
+	req.Header.Set("If-None-Match", `"other"`)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "etag", w.Body.String())
+}
+
+func TestHTTPSemanticsValidatorsOnMiss(t *testing.T) {
+	store := newMemoryStore()
+	r := gin.New()
+	r.GET("/etag", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.String(http.StatusOK, "etag")
+	}, WithHTTPSemantics()))
+
+	miss := performRequest(r, http.MethodGet, "/etag")
+	hit := performRequest(r, http.MethodGet, "/etag")
+
+	assert.Equal(t, "etag", miss.Body.String())
+	assert.NotEmpty(t, miss.Header().Get("ETag"))
+	assert.Equal(t, hit.Header().Get("ETag"), miss.Header().Get("ETag"))
+	assert.Equal(t, hit.Header().Get("Last-Modified"), miss.Header().Get("Last-Modified"))
+}
+
+func TestHTTPSemanticsMethods(t *testing.T) {
+	store := newMemoryStore()
+	calls := map[string]int{}
+	r := gin.New()
+	r.Use(SiteCache(store, time.Minute, WithHTTPSemantics()))
+	handler := func(c *gin.Context) {
+		calls[c.Request.Method]++
+		c.String(http.StatusOK, c.Request.Method)
+	}
+	r.GET("/cart", handler)
+	r.HEAD("/cart", handler)
+	r.POST("/cart", handler)
+	r.DELETE("/cart", func(c *gin.Context) {
+		calls[c.Request.Method]++
+		c.Status(http.StatusForbidden)
+	})
+
+	performRequest(r, http.MethodHead, "/cart")
+	performRequest(r, http.MethodGet, "/cart")
+	performRequest(r, http.MethodHead, "/cart")
+	assert.Equal(t, 1, calls[http.MethodHead])
+
+	w := performRequest(r, http.MethodPost, "/cart")
+	assert.Equal(t, "POST", w.Body.String())
+	assert.Equal(t, 1, calls[http.MethodPost])
+
+	// the POST invalidated the page
+	performRequest(r, http.MethodGet, "/cart")
+	assert.Equal(t, 2, calls[http.MethodGet])
+
+	// a failed unsafe request does not
+	performRequest(r, http.MethodDelete, "/cart")
+	performRequest(r, http.MethodGet, "/cart")
+	assert.Equal(t, 1, calls[http.MethodDelete])
+	assert.Equal(t, 2, calls[http.MethodGet])
+}
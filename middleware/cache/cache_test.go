@@ -0,0 +1,259 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/donetkit/contrib/utils/cache"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryStore is a minimal in-memory cache.ICache used by the tests.
+type memoryStore struct {
+	cache.ICache
+	mu      sync.Mutex
+	items   map[string]interface{}
+	expires map[string]time.Duration
+	hashes  map[string]map[string]string
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		items:   make(map[string]interface{}),
+		expires: make(map[string]time.Duration),
+		hashes:  make(map[string]map[string]string),
+	}
+}
+
+func (s *memoryStore) WithDB(int) cache.ICache { return s }
+
+func (s *memoryStore) WithContext(context.Context) cache.ICache { return s }
+
+func (s *memoryStore) Get(key string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.items[key]
+}
+
+func (s *memoryStore) Set(key string, val interface{}, expire time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[key] = val
+	s.expires[key] = expire
+	return nil
+}
+
+func (s *memoryStore) Delete(keys ...string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for _, key := range keys {
+		if _, ok := s.items[key]; ok {
+			delete(s.items, key)
+			n++
+		}
+		if _, ok := s.hashes[key]; ok {
+			delete(s.hashes, key)
+			n++
+		}
+	}
+	return n
+}
+
+func (s *memoryStore) HashSet(key string, values ...interface{}) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.hashes[key] == nil {
+		s.hashes[key] = make(map[string]string)
+	}
+	for i := 0; i+1 < len(values); i += 2 {
+		s.hashes[key][values[i].(string)] = values[i+1].(string)
+	}
+	return int64(len(values) / 2)
+}
+
+func (s *memoryStore) HashAll(key string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all := make(map[string]string, len(s.hashes[key]))
+	for k, v := range s.hashes[key] {
+		all[k] = v
+	}
+	return all
+}
+
+func (s *memoryStore) HashKeys(key string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for k := range s.hashes[key] {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (s *memoryStore) HashDel(key string, fields ...string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for _, field := range fields {
+		if _, ok := s.hashes[key][field]; ok {
+			delete(s.hashes[key], field)
+			n++
+		}
+	}
+	return n
+}
+
+// redisStore round-trips values through JSON like the redis cache.ICache does.
+type redisStore struct {
+	*memoryStore
+}
+
+func (s redisStore) WithDB(int) cache.ICache { return s }
+
+func (s redisStore) WithContext(context.Context) cache.ICache { return s }
+
+func (s redisStore) Get(key string) interface{} {
+	data, ok := s.memoryStore.Get(key).([]byte)
+	if !ok {
+		return nil
+	}
+	var reply interface{}
+	if err := json.Unmarshal(data, &reply); err != nil {
+		return nil
+	}
+	return reply
+}
+
+func (s redisStore) Set(key string, val interface{}, expire time.Duration) error {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return s.memoryStore.Set(key, data, expire)
+}
+
+func performRequest(r http.Handler, method, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestCachePageMultipleWrites(t *testing.T) {
+	store := newMemoryStore()
+	calls := 0
+	r := gin.New()
+	r.GET("/page", CachePage(store, time.Minute, func(c *gin.Context) {
+		calls++
+		c.Status(http.StatusOK)
+		for _, part := range []string{"<html>", "<body>", "</body>", "</html>"} {
+			c.Writer.Write([]byte(part))
+		}
+	}))
+
+	w1 := performRequest(r, http.MethodGet, "/page")
+	w2 := performRequest(r, http.MethodGet, "/page")
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, "<html><body></body></html>", w1.Body.String())
+	assert.Equal(t, w1.Body.String(), w2.Body.String())
+}
+
+func TestCachePageSkipsErrorStatus(t *testing.T) {
+	store := newMemoryStore()
+	calls := 0
+	r := gin.New()
+	r.GET("/error", CachePage(store, time.Minute, func(c *gin.Context) {
+		calls++
+		c.String(http.StatusInternalServerError, "error")
+	}))
+
+	performRequest(r, http.MethodGet, "/error")
+	w := performRequest(r, http.MethodGet, "/error")
+
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestCachePageCacheableStatus(t *testing.T) {
+	store := newMemoryStore()
+	calls := 0
+	r := gin.New()
+	r.GET("/missing", CachePage(store, time.Minute, func(c *gin.Context) {
+		calls++
+		c.String(http.StatusNotFound, "missing")
+	}, WithCacheableStatus(http.StatusOK, http.StatusNotFound)))
+
+	performRequest(r, http.MethodGet, "/missing")
+	w := performRequest(r, http.MethodGet, "/missing")
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "missing", w.Body.String())
+}
+
+func TestCachePageMaxBodySize(t *testing.T) {
+	store := newMemoryStore()
+	calls := 0
+	r := gin.New()
+	r.GET("/large", CachePage(store, time.Minute, func(c *gin.Context) {
+		calls++
+		c.String(http.StatusOK, "0123456789")
+	}, WithMaxBodySize(8)))
+
+	performRequest(r, http.MethodGet, "/large")
+	w := performRequest(r, http.MethodGet, "/large")
+
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, "0123456789", w.Body.String())
+}
+
+func TestSiteCache(t *testing.T) {
+	store := newMemoryStore()
+	calls := 0
+	r := gin.New()
+	r.Use(SiteCache(store, time.Minute))
+	r.GET("/site", func(c *gin.Context) {
+		calls++
+		c.Header("Content-Type", "text/plain")
+		c.Writer.WriteString("site")
+		c.Writer.WriteString(" page")
+	})
+
+	performRequest(r, http.MethodGet, "/site")
+	w := performRequest(r, http.MethodGet, "/site")
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, "site page", w.Body.String())
+	assert.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+}
+
+func TestCachePageSharedStore(t *testing.T) {
+	store := redisStore{newMemoryStore()}
+	calls := 0
+	handle := func(c *gin.Context) {
+		calls++
+		c.Header("Content-Type", "text/html")
+		c.String(http.StatusOK, strings.Repeat("<p>page</p>", 100))
+	}
+	// two routers stand in for two processes sharing the store
+	r1, r2 := gin.New(), gin.New()
+	r1.GET("/shared", CachePage(store, time.Minute, handle, WithGzipBody(512)))
+	r2.GET("/shared", CachePage(store, time.Minute, handle, WithGzipBody(512)))
+
+	w1 := performRequest(r1, http.MethodGet, "/shared")
+	w2 := performRequest(r2, http.MethodGet, "/shared")
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, w1.Body.String(), w2.Body.String())
+	assert.Equal(t, "text/html", w2.Header().Get("Content-Type"))
+}
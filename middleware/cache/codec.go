@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// envelopeVersion is bumped whenever the envelope changes incompatibly,
+// entries written with another version are treated as a miss.
+const envelopeVersion = 1
+
+const encodingGzip = "gzip"
+
+var errEnvelopeVersion = errors.New("cache: unsupported envelope version")
+
+// envelope is the wire format of a cached response. It is stored as a JSON string,
+// so any cache.ICache that round-trips strings can share pages between processes.
+type envelope struct {
+	Version              int         `json:"v"`
+	Status               int         `json:"status,omitempty"`
+	Header               http.Header `json:"header,omitempty"`
+	Encoding             string      `json:"encoding,omitempty"`
+	Body                 []byte      `json:"body,omitempty"`
+	Stored               int64       `json:"stored,omitempty"`
+	Expires              int64       `json:"expires,omitempty"`
+	StaleWhileRevalidate int64       `json:"swr,omitempty"`
+	StaleIfError         int64       `json:"sie,omitempty"`
+	Vary                 []string    `json:"vary,omitempty"`
+}
+
+// encodeResponseCache serializes r, its body is gzip-compressed when it is at least gzipMinSize
+// bytes long and not already content-encoded. A gzipMinSize of 0 disables compression.
+func encodeResponseCache(r responseCache, gzipMinSize int) (string, error) {
+	e := envelope{
+		Version:              envelopeVersion,
+		Status:               r.status,
+		Header:               r.header,
+		Body:                 r.data,
+		Stored:               unixNano(r.stored),
+		Expires:              unixNano(r.expires),
+		StaleWhileRevalidate: int64(r.staleWhileRevalidate),
+		StaleIfError:         int64(r.staleIfError),
+		Vary:                 r.vary,
+	}
+	if gzipMinSize > 0 && len(r.data) >= gzipMinSize && r.header.Get("Content-Encoding") == "" {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(r.data); err != nil {
+			return "", err
+		}
+		if err := zw.Close(); err != nil {
+			return "", err
+		}
+		e.Encoding, e.Body = encodingGzip, buf.Bytes()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// decodeResponseCache deserializes a value read from the store.
+func decodeResponseCache(v interface{}) (responseCache, error) {
+	var b []byte
+	switch data := v.(type) {
+	case string:
+		b = []byte(data)
+	case []byte:
+		b = data
+	default:
+		return responseCache{}, ErrCacheMiss
+	}
+	var e envelope
+	if err := json.Unmarshal(b, &e); err != nil {
+		return responseCache{}, err
+	}
+	if e.Version != envelopeVersion {
+		return responseCache{}, errEnvelopeVersion
+	}
+	if e.Encoding == encodingGzip {
+		zr, err := gzip.NewReader(bytes.NewReader(e.Body))
+		if err != nil {
+			return responseCache{}, err
+		}
+		if e.Body, err = io.ReadAll(zr); err != nil {
+			return responseCache{}, err
+		}
+	}
+	return responseCache{
+		status:               e.Status,
+		header:               e.Header,
+		data:                 e.Body,
+		stored:               fromUnixNano(e.Stored),
+		expires:              fromUnixNano(e.Expires),
+		staleWhileRevalidate: time.Duration(e.StaleWhileRevalidate),
+		staleIfError:         time.Duration(e.StaleIfError),
+		vary:                 e.Vary,
+	}, nil
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
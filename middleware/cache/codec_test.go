@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseCacheCodec(t *testing.T) {
+	stored := time.Now()
+	r := responseCache{
+		status:               http.StatusOK,
+		header:               http.Header{"Content-Type": {"text/plain"}},
+		data:                 []byte(strings.Repeat("body", 64)),
+		stored:               stored,
+		expires:              stored.Add(time.Minute),
+		staleWhileRevalidate: time.Second,
+		staleIfError:         time.Hour,
+	}
+
+	for _, gzipMinSize := range []int{0, 1} {
+		data, err := encodeResponseCache(r, gzipMinSize)
+		assert.NoError(t, err)
+		assert.Equal(t, gzipMinSize > 0, strings.Contains(data, `"encoding":"gzip"`))
+
+		decoded, err := decodeResponseCache(data)
+		assert.NoError(t, err)
+		assert.Equal(t, r.status, decoded.status)
+		assert.Equal(t, r.header, decoded.header)
+		assert.Equal(t, r.data, decoded.data)
+		assert.True(t, r.stored.Equal(decoded.stored))
+		assert.True(t, r.expires.Equal(decoded.expires))
+		assert.Equal(t, r.staleWhileRevalidate, decoded.staleWhileRevalidate)
+		assert.Equal(t, r.staleIfError, decoded.staleIfError)
+	}
+}
+
+func TestResponseCacheCodecVersion(t *testing.T) {
+	_, err := decodeResponseCache(`{"v":0,"status":200}`)
+	assert.Equal(t, errEnvelopeVersion, err)
+
+	_, err = decodeResponseCache(nil)
+	assert.Equal(t, ErrCacheMiss, err)
+}
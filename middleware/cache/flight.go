@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// flight is a page regeneration in progress, waiters block on done until the leader is finished.
+type flight struct {
+	done  chan struct{}
+	cache responseCache
+	ok    bool
+}
+
+// wait waits up to timeout for the leader, 0 waits as long as it takes. It reports whether
+// the leader has finished, the waiter regenerates the page itself otherwise.
+func (f *flight) wait(timeout time.Duration) bool {
+	if timeout <= 0 {
+		<-f.done
+		return true
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-f.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// flightGroup makes sure only one request per key regenerates a page at a time.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// join returns the flight for key, leader is true when the caller started it and must call done.
+func (g *flightGroup) join(key string) (f *flight, leader bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f, ok := g.flights[key]; ok {
+		return f, false
+	}
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	f = &flight{done: make(chan struct{})}
+	g.flights[key] = f
+	return f, true
+}
+
+// done publishes the regenerated page to the waiters of the flight and forgets it.
+func (g *flightGroup) done(key string, f *flight, cache responseCache, ok bool) {
+	g.mu.Lock()
+	delete(g.flights, key)
+	g.mu.Unlock()
+	f.cache, f.ok = cache, ok
+	close(f.done)
+}
@@ -1,17 +1,79 @@
 package cache
 
 import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
 	"github.com/donetkit/contrib-log/glog"
 	"github.com/donetkit/contrib/utils/cache"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// DefaultCacheableStatus is the set of status codes stored when WithCacheableStatus is not given.
+	DefaultCacheableStatus = []int{http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent}
+	// DefaultMaxBodySize is the largest response body stored when WithMaxBodySize is not given.
+	DefaultMaxBodySize = 1024 * 1024
+	// DefaultRefreshTimeout bounds background refreshes and coalesced waits when WithRefreshTimeout is not given.
+	DefaultRefreshTimeout = 30 * time.Second
 )
 
+// KeyFunc returns the part of the cache key that identifies the page requested by c.
+type KeyFunc func(c *gin.Context) string
+
 type option struct {
-	cache  cache.ICache
-	logger glog.ILoggerEntry
+	cache           cache.ICache
+	logger          glog.ILoggerEntry
+	db              int
+	keyFunc         KeyFunc
+	expire          time.Duration
+	routeExpire     map[string]time.Duration
+	cacheableStatus map[int]struct{}
+	maxBodySize     int
+	httpSemantics   bool
+	coalescing      bool
+	index           bool
+	gzipMinSize     int
+	// staleWhileRevalidate and staleIfError extend how long an expired page may still be served
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+	refreshTimeout       time.Duration
+	flights              *flightGroup
+	pruner               *pruner
 }
 
 type Option func(*option)
 
+func newOption(opts ...Option) *option {
+	o := &option{
+		keyFunc:        requestURIKey,
+		maxBodySize:    DefaultMaxBodySize,
+		refreshTimeout: DefaultRefreshTimeout,
+		flights:        &flightGroup{},
+		pruner:         &pruner{},
+	}
+	WithCacheableStatus(DefaultCacheableStatus...)(o)
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// with returns a copy of o with opts applied.
+func (o *option) with(opts ...Option) *option {
+	cp := *o
+	cp.routeExpire = make(map[string]time.Duration, len(o.routeExpire))
+	for path, expire := range o.routeExpire {
+		cp.routeExpire[path] = expire
+	}
+	for _, opt := range opts {
+		opt(&cp)
+	}
+	return &cp
+}
+
 func WithLogger(logger glog.ILogger) Option {
 	return func(o *option) {
 		o.logger = logger.WithField("Cache", "Cache")
@@ -24,3 +86,156 @@ func WithCache(cache cache.ICache) Option {
 		o.cache = cache
 	}
 }
+
+// WithDB  select the db of the cache, default 0
+func WithDB(db int) Option {
+	return func(o *option) {
+		o.db = db
+	}
+}
+
+// WithKeyFunc set the function deriving the cache key of a page, default the request URI
+func WithKeyFunc(fn KeyFunc) Option {
+	return func(o *option) {
+		o.keyFunc = fn
+	}
+}
+
+// WithExpire set how long a page is stored
+func WithExpire(expire time.Duration) Option {
+	return func(o *option) {
+		o.expire = expire
+	}
+}
+
+// WithRouteExpire override the expire for the route registered as fullPath, e.g. "/products/:id"
+func WithRouteExpire(fullPath string, expire time.Duration) Option {
+	return func(o *option) {
+		if o.routeExpire == nil {
+			o.routeExpire = make(map[string]time.Duration)
+		}
+		o.routeExpire[fullPath] = expire
+	}
+}
+
+// WithCacheableStatus set the response status codes that may be stored, default 200, 203 and 204
+func WithCacheableStatus(status ...int) Option {
+	return func(o *option) {
+		o.cacheableStatus = make(map[int]struct{}, len(status))
+		for _, code := range status {
+			o.cacheableStatus[code] = struct{}{}
+		}
+	}
+}
+
+// WithMaxBodySize set the largest response body in bytes that may be stored, 0 means no limit
+func WithMaxBodySize(size int) Option {
+	return func(o *option) {
+		o.maxBodySize = size
+	}
+}
+
+// WithHTTPSemantics honour Cache-Control, Vary and conditional requests as described in RFC 9111:
+// only GET responses are stored and served, also to HEAD, and unsafe methods invalidate the page,
+// requests with no-cache bypass the cache, private, no-store and no-cache responses are not stored,
+// s-maxage and max-age override the expire, the key includes the Vary request headers, and stored
+// responses get an ETag and Last-Modified used to answer If-None-Match and If-Modified-Since with 304.
+// Responses are buffered until the handler returns, so the validators are already sent with the first.
+func WithHTTPSemantics() Option {
+	return func(o *option) {
+		o.httpSemantics = true
+	}
+}
+
+// WithCoalescing let only one request per page run the handler when the page is missing,
+// concurrent requests for the same page wait for it and share its response. They wait up to
+// the refresh timeout, then run the handler themselves.
+func WithCoalescing() Option {
+	return func(o *option) {
+		o.coalescing = true
+	}
+}
+
+// WithStaleWhileRevalidate serve an expired page for up to d while it is refreshed in the background.
+// The refresh runs the handler with a copy of the context, see gin.Context.Copy, whose request is not
+// cancelled with it, see WithRefreshTimeout. Site can not run the rest of the chain in the background,
+// there the request starting the refresh waits for it.
+func WithStaleWhileRevalidate(d time.Duration) Option {
+	return func(o *option) {
+		o.staleWhileRevalidate = d
+	}
+}
+
+// WithRefreshTimeout set the deadline of background refreshes and how long coalesced requests
+// wait for the one regenerating the page, default DefaultRefreshTimeout, 0 means no timeout
+func WithRefreshTimeout(d time.Duration) Option {
+	return func(o *option) {
+		o.refreshTimeout = d
+	}
+}
+
+// WithStaleIfError serve an expired page for up to d when regenerating it fails with a 5xx status
+func WithStaleIfError(d time.Duration) Option {
+	return func(o *option) {
+		o.staleIfError = d
+	}
+}
+
+// WithPurgeIndex record every stored page in an index hash, so it can be evicted with PurgeURL
+// and PurgePrefix. Tagged pages are always indexed. The store must support the hash commands.
+// The entries of expired pages are pruned in the background once a minute, and when purging.
+func WithPurgeIndex() Option {
+	return func(o *option) {
+		o.index = true
+	}
+}
+
+// WithGzipBody gzip-compress stored bodies of at least minSize bytes, unless the response
+// already has a Content-Encoding. 0 disables compression, which is the default.
+func WithGzipBody(minSize int) Option {
+	return func(o *option) {
+		o.gzipMinSize = minSize
+	}
+}
+
+func (o *option) isCacheableStatus(code int) bool {
+	_, ok := o.cacheableStatus[code]
+	return ok
+}
+
+func (o *option) store(ctx context.Context) cache.ICache {
+	return o.cache.WithDB(o.db).WithContext(ctx)
+}
+
+func (o *option) expireFor(c *gin.Context) time.Duration {
+	if expire, ok := o.routeExpire[c.FullPath()]; ok {
+		return expire
+	}
+	return o.expire
+}
+
+func requestURIKey(c *gin.Context) string {
+	return c.Request.URL.RequestURI()
+}
+
+// KeyWithSortedQuery returns a KeyFunc made of the request path and the query parameters sorted
+// by name, so their order does not matter. Parameters starting with one of ignorePrefixes, e.g.
+// "utm_", are left out.
+func KeyWithSortedQuery(ignorePrefixes ...string) KeyFunc {
+	return func(c *gin.Context) string {
+		query := c.Request.URL.Query()
+		for name := range query {
+			for _, prefix := range ignorePrefixes {
+				if strings.HasPrefix(name, prefix) {
+					query.Del(name)
+					break
+				}
+			}
+		}
+		if len(query) == 0 {
+			return c.Request.URL.EscapedPath()
+		}
+		// Encode sorts by key
+		return c.Request.URL.EscapedPath() + "?" + query.Encode()
+	}
+}
@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyWithSortedQuery(t *testing.T) {
+	store := newMemoryStore()
+	calls := 0
+	pages := New(WithCache(store), WithExpire(time.Minute), WithKeyFunc(KeyWithSortedQuery("utm_")))
+	r := gin.New()
+	r.GET("/list", pages.Page(func(c *gin.Context) {
+		calls++
+		c.String(http.StatusOK, "list")
+	}))
+
+	performRequest(r, http.MethodGet, "/list?b=2&a=1&utm_source=mail")
+	performRequest(r, http.MethodGet, "/list?a=1&b=2")
+	performRequest(r, http.MethodGet, "/list?a=1&b=3")
+
+	assert.Equal(t, 2, calls)
+}
+
+func TestRouteExpire(t *testing.T) {
+	store := newMemoryStore()
+	pages := New(WithCache(store), WithExpire(time.Minute), WithRouteExpire("/products/:id", time.Hour))
+	r := gin.New()
+	r.Use(pages.Site())
+	handle := func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	}
+	r.GET("/products/:id", handle)
+	r.GET("/home", handle)
+
+	performRequest(r, http.MethodGet, "/products/1")
+	performRequest(r, http.MethodGet, "/home")
+
+	assert.Equal(t, time.Hour, store.expires[urlEscape(PageCachePrefix, "/products/1")])
+	assert.Equal(t, time.Minute, store.expires[urlEscape(PageCachePrefix, "/home")])
+}
+
+func TestPageOptionOverride(t *testing.T) {
+	store := newMemoryStore()
+	pages := New(WithCache(store), WithExpire(time.Minute), WithPurgeIndex())
+	r := gin.New()
+	r.GET("/about", pages.Page(func(c *gin.Context) {
+		c.String(http.StatusOK, "about")
+	}, WithExpire(time.Second)))
+
+	performRequest(r, http.MethodGet, "/about")
+
+	key := urlEscape(PageCachePrefix, "/about")
+	assert.Equal(t, time.Second, store.expires[key])
+	assert.Equal(t, int64(1), pages.PurgeURL(context.Background(), "/about"))
+}
@@ -0,0 +1,223 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/donetkit/contrib/utils/cache"
+	"github.com/gin-gonic/gin"
+)
+
+const tagsKey = "github.com/donetkit/contrib-gin/middleware/cache/tags"
+
+// Tag attaches surrogate tags to the page being generated, so it can be evicted with PurgeTag.
+func Tag(c *gin.Context, tags ...string) {
+	if v, ok := c.Get(tagsKey); ok {
+		tags = append(v.([]string), tags...)
+	}
+	c.Set(tagsKey, tags)
+}
+
+func purgeURL(db cache.ICache, uri string) int64 {
+	return purgeIndex(db, func(indexed string) bool {
+		return indexed == uri
+	}, urlEscape(PageCachePrefix, uri))
+}
+
+func purgePrefix(db cache.ICache, prefix string) int64 {
+	return purgeIndex(db, func(indexed string) bool {
+		path, _, _ := strings.Cut(indexed, "?")
+		return strings.HasPrefix(path, prefix)
+	})
+}
+
+func purgeTag(db cache.ICache, tags ...string) int64 {
+	var n int64
+	for _, tag := range tags {
+		keys := db.HashKeys(tagKey(tag))
+		if len(keys) > 0 {
+			n += db.Delete(keys...)
+			db.HashDel(indexKey(), keys...)
+		}
+		db.Delete(tagKey(tag))
+		db.HashDel(tagIndexKey(), tag)
+	}
+	return n
+}
+
+// purgeIndex removes the entries of the index whose request URI matches, together with keys.
+// Entries of expired pages are removed on the way.
+func purgeIndex(db cache.ICache, match func(uri string) bool, keys ...string) int64 {
+	var indexed, dead []string
+	now := time.Now()
+	for key, value := range db.HashAll(indexKey()) {
+		entry := parseIndexEntry(value)
+		switch {
+		case entry.dead(now):
+			dead = append(dead, key)
+		case match(entry.uri):
+			indexed = append(indexed, key)
+		}
+	}
+	if len(indexed)+len(dead) > 0 {
+		db.HashDel(indexKey(), append(indexed, dead...)...)
+		keys = append(keys, indexed...)
+	}
+	if len(keys) == 0 {
+		return 0
+	}
+	return db.Delete(keys...)
+}
+
+// index records a stored key, which expires after ttl, under the request URI and the tags of the page.
+func (w *cachedWriter) index(key string, ttl time.Duration) {
+	var tags []string
+	if v, ok := w.c.Get(tagsKey); ok {
+		tags = v.([]string)
+	}
+	if !w.opt.index && len(tags) == 0 {
+		return
+	}
+	db := w.opt.store(w.c.Request.Context())
+	entry := indexEntry{uri: w.c.Request.URL.RequestURI()}
+	if ttl > 0 {
+		entry.deadline = time.Now().Add(ttl).UnixMilli()
+	}
+	value := entry.String()
+	db.HashSet(indexKey(), key, value)
+	for _, tag := range tags {
+		db.HashSet(tagKey(tag), key, value)
+		db.HashSet(tagIndexKey(), tag, strconv.FormatInt(entry.deadline, 10))
+	}
+	w.opt.startPruning()
+}
+
+// pruneInterval is how often stores prune the index and tag entries of expired pages.
+var pruneInterval = time.Minute
+
+// pruners records the stores, and their db, pruned in the background.
+var pruners = struct {
+	sync.Mutex
+	started map[pruneTarget]bool
+}{started: make(map[pruneTarget]bool)}
+
+// pruneTarget identifies a db of a store. Stores which are not comparable are told apart by the
+// pruner of the PageCache instead, so the PageCaches sharing them each prune.
+type pruneTarget struct {
+	store cache.ICache
+	owner *pruner
+	db    int
+}
+
+// pruner identifies the PageCache of a store which is not comparable.
+type pruner struct {
+	_ byte
+}
+
+// startPruning starts pruning the db of the store every pruneInterval in the background, once per
+// store and db. It runs for the life of the process.
+func (o *option) startPruning() {
+	target := pruneTarget{db: o.db}
+	if reflect.TypeOf(o.cache).Comparable() {
+		target.store = o.cache
+	} else {
+		target.owner = o.pruner
+	}
+	pruners.Lock()
+	defer pruners.Unlock()
+	if pruners.started[target] {
+		return
+	}
+	pruners.started[target] = true
+	db := o.cache.WithDB(o.db).WithContext(context.Background())
+	ticker := time.NewTicker(pruneInterval)
+	go func() {
+		defer ticker.Stop()
+		for range ticker.C {
+			prune(db)
+		}
+	}()
+}
+
+// prune removes the index and tag entries of expired pages, and the tags left without pages.
+func prune(db cache.ICache) {
+	now := time.Now()
+	pruneHash(db, indexKey(), now)
+	for tag, value := range db.HashAll(tagIndexKey()) {
+		// the deadline of the last page stored with the tag, the others may outlive it
+		deadline, _ := strconv.ParseInt(value, 10, 64)
+		if deadline == 0 || now.UnixMilli() < deadline {
+			continue
+		}
+		if latest, alive := pruneHash(db, tagKey(tag), now); alive {
+			db.HashSet(tagIndexKey(), tag, strconv.FormatInt(latest, 10))
+		} else {
+			db.HashDel(tagIndexKey(), tag)
+		}
+	}
+}
+
+// pruneHash removes the entries of expired pages from the hash key. It returns the latest deadline
+// of the remaining entries, 0 when one of them does not expire, and whether any remain.
+func pruneHash(db cache.ICache, key string, now time.Time) (latest int64, alive bool) {
+	var dead []string
+	forever := false
+	for field, value := range db.HashAll(key) {
+		entry := parseIndexEntry(value)
+		if entry.dead(now) {
+			dead = append(dead, field)
+			continue
+		}
+		alive = true
+		if entry.deadline == 0 {
+			forever = true
+		} else if entry.deadline > latest {
+			latest = entry.deadline
+		}
+	}
+	if len(dead) > 0 {
+		db.HashDel(key, dead...)
+	}
+	if forever {
+		latest = 0
+	}
+	return latest, alive
+}
+
+// indexEntry is the value of a page in the index and tag hashes: the request URI of the page and
+// the unix time in milliseconds it expires at, 0 if it does not.
+type indexEntry struct {
+	uri      string
+	deadline int64
+}
+
+func (e indexEntry) String() string {
+	return strconv.FormatInt(e.deadline, 10) + " " + e.uri
+}
+
+func (e indexEntry) dead(now time.Time) bool {
+	return e.deadline != 0 && now.UnixMilli() >= e.deadline
+}
+
+func parseIndexEntry(value string) indexEntry {
+	deadline, uri, _ := strings.Cut(value, " ")
+	n, _ := strconv.ParseInt(deadline, 10, 64)
+	return indexEntry{uri: uri, deadline: n}
+}
+
+func indexKey() string {
+	return PageCachePrefix + ":index"
+}
+
+func tagKey(tag string) string {
+	return PageCachePrefix + ":tag:" + tag
+}
+
+// tagIndexKey is the hash of the tags in use, with the deadline of the last page stored with each.
+func tagIndexKey() string {
+	return PageCachePrefix + ":tags"
+}
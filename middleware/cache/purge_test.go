@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/donetkit/contrib/utils/cache"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPurgeRouter(store *memoryStore, calls map[string]int) (*gin.Engine, *PageCache) {
+	pages := New(WithCache(store), WithExpire(time.Minute), WithPurgeIndex())
+	r := gin.New()
+	r.Use(pages.Site())
+	r.GET("/products/:id", func(c *gin.Context) {
+		calls[c.Request.URL.RequestURI()]++
+		Tag(c, "product:"+c.Param("id"))
+		c.String(http.StatusOK, c.Param("id"))
+	})
+	r.GET("/home", func(c *gin.Context) {
+		calls[c.Request.URL.RequestURI()]++
+		Tag(c, "product:1", "product:2")
+		c.String(http.StatusOK, "home")
+	})
+	return r, pages
+}
+
+func TestPurgeURL(t *testing.T) {
+	store := newMemoryStore()
+	calls := map[string]int{}
+	r, pages := newPurgeRouter(store, calls)
+
+	performRequest(r, http.MethodGet, "/products/1?page=2")
+	performRequest(r, http.MethodGet, "/products/1")
+	assert.Equal(t, int64(1), pages.PurgeURL(context.Background(), "/products/1?page=2"))
+	performRequest(r, http.MethodGet, "/products/1?page=2")
+	performRequest(r, http.MethodGet, "/products/1")
+
+	assert.Equal(t, 2, calls["/products/1?page=2"])
+	assert.Equal(t, 1, calls["/products/1"])
+}
+
+func TestPurgePrefix(t *testing.T) {
+	store := newMemoryStore()
+	calls := map[string]int{}
+	r, pages := newPurgeRouter(store, calls)
+
+	for _, uri := range []string{"/products/1", "/products/2", "/home"} {
+		performRequest(r, http.MethodGet, uri)
+	}
+	assert.Equal(t, int64(2), pages.PurgePrefix(context.Background(), "/products/"))
+	for _, uri := range []string{"/products/1", "/products/2", "/home"} {
+		performRequest(r, http.MethodGet, uri)
+	}
+
+	assert.Equal(t, 2, calls["/products/1"])
+	assert.Equal(t, 2, calls["/products/2"])
+	assert.Equal(t, 1, calls["/home"])
+}
+
+func TestPurgeTag(t *testing.T) {
+	store := newMemoryStore()
+	calls := map[string]int{}
+	r, pages := newPurgeRouter(store, calls)
+
+	for _, uri := range []string{"/products/1", "/products/2", "/home"} {
+		performRequest(r, http.MethodGet, uri)
+	}
+	assert.Equal(t, int64(2), pages.PurgeTag(context.Background(), "product:1"))
+	for _, uri := range []string{"/products/1", "/products/2", "/home"} {
+		performRequest(r, http.MethodGet, uri)
+	}
+
+	assert.Equal(t, 2, calls["/products/1"])
+	assert.Equal(t, 1, calls["/products/2"])
+	assert.Equal(t, 2, calls["/home"])
+}
+
+func TestPruneExpiredEntries(t *testing.T) {
+	defer func(interval time.Duration) { pruneInterval = interval }(pruneInterval)
+	pruneInterval = 5 * time.Millisecond
+	store := newMemoryStore()
+	r := gin.New()
+	r.Use(SiteCache(store, 10*time.Millisecond, WithPurgeIndex(), WithRouteExpire("/home", time.Minute)))
+	r.GET("/products/:id", func(c *gin.Context) {
+		Tag(c, "product:"+c.Param("id"))
+		c.String(http.StatusOK, c.Param("id"))
+	})
+	r.GET("/home", func(c *gin.Context) {
+		Tag(c, "home")
+		c.String(http.StatusOK, "home")
+	})
+
+	performRequest(r, http.MethodGet, "/products/1")
+	performRequest(r, http.MethodGet, "/products/2")
+	time.Sleep(20 * time.Millisecond)
+	performRequest(r, http.MethodGet, "/home")
+
+	// the store is pruned in the background
+	assert.Eventually(t, func() bool {
+		return len(store.HashAll(indexKey())) == 1 && len(store.HashAll(tagIndexKey())) == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Empty(t, store.HashAll(tagKey("product:1")))
+	assert.Contains(t, store.HashAll(tagIndexKey()), "home")
+
+	// and when purging
+	pruneInterval = time.Hour
+	store = newMemoryStore()
+	pages := New(WithCache(store), WithExpire(10*time.Millisecond), WithPurgeIndex(), WithRouteExpire("/home", time.Minute))
+	r = gin.New()
+	r.Use(pages.Site())
+	r.GET("/products/:id", func(c *gin.Context) {
+		c.String(http.StatusOK, c.Param("id"))
+	})
+	r.GET("/home", func(c *gin.Context) {
+		c.String(http.StatusOK, "home")
+	})
+	performRequest(r, http.MethodGet, "/home")
+	performRequest(r, http.MethodGet, "/products/3")
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int64(1), pages.PurgeURL(context.Background(), "/home"))
+	assert.Empty(t, store.HashAll(indexKey()))
+}
+
+// dbStore keeps a memoryStore per db.
+type dbStore struct {
+	*memoryStore
+	dbs map[int]*memoryStore
+}
+
+func (s dbStore) WithDB(db int) cache.ICache {
+	if s.dbs[db] == nil {
+		s.dbs[db] = newMemoryStore()
+	}
+	return s.dbs[db]
+}
+
+func TestPurgeDB(t *testing.T) {
+	store := dbStore{memoryStore: newMemoryStore(), dbs: map[int]*memoryStore{}}
+	pages := New(WithCache(store), WithExpire(time.Minute), WithDB(3))
+	r := gin.New()
+	r.Use(pages.Site())
+	r.GET("/products/:id", func(c *gin.Context) {
+		Tag(c, "product:"+c.Param("id"))
+		c.String(http.StatusOK, c.Param("id"))
+	})
+
+	performRequest(r, http.MethodGet, "/products/1")
+	performRequest(r, http.MethodGet, "/products/2")
+
+	assert.Equal(t, int64(1), pages.PurgeTag(context.Background(), "product:1"))
+	assert.Equal(t, int64(1), pages.PurgePrefix(context.Background(), "/products/"))
+	assert.Nil(t, store.dbs[3].Get(urlEscape(PageCachePrefix, "/products/2")))
+}
@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// refreshPage regenerates the page in the background while the stale copy is served,
+// the leader of flight f. The response only goes to the cache.
+func refreshPage(c *gin.Context, key string, opt *option, handle gin.HandlerFunc, f *flight) {
+	// the copy has no route, so the expire is taken from the request
+	expire := opt.expireFor(c)
+	ctx, cancel := detach(c.Request.Context(), opt.refreshTimeout)
+	rc := c.Copy()
+	rc.Request = rc.Request.WithContext(ctx)
+	rc.Writer = &discardWriter{header: make(http.Header), status: http.StatusOK, size: -1}
+	go func() {
+		var (
+			cache responseCache
+			ok    bool
+		)
+		defer func() {
+			cancel()
+			if p := recover(); p != nil && opt.logger != nil {
+				opt.logger.Errorf("refresh page cache %s: panic: %v", key, p)
+			}
+			opt.flights.done(key, f, cache, ok)
+		}()
+		writer := newCachedWriter(rc, expire, rc.Writer, key, opt)
+		rc.Writer = writer
+		handle(rc)
+		writer.validate()
+		cache, ok = writer.commit()
+	}()
+}
+
+// detachedContext keeps the values of the request context without its cancellation,
+// the refresh outlives the request.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (detachedContext) Done() <-chan struct{} { return nil }
+
+func (detachedContext) Err() error { return nil }
+
+// detach returns a context with the values of ctx which is cancelled after timeout, 0 is no timeout.
+func detach(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(detachedContext{ctx})
+	}
+	return context.WithTimeout(detachedContext{ctx}, timeout)
+}
+
+// discardWriter is the response writer of background refreshes.
+type discardWriter struct {
+	header http.Header
+	status int
+	size   int
+}
+
+func (w *discardWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardWriter) WriteHeader(code int) {
+	if code > 0 && !w.Written() {
+		w.status = code
+	}
+}
+
+func (w *discardWriter) WriteHeaderNow() {
+	if !w.Written() {
+		w.size = 0
+	}
+}
+
+func (w *discardWriter) Write(data []byte) (int, error) {
+	w.WriteHeaderNow()
+	w.size += len(data)
+	return len(data), nil
+}
+
+func (w *discardWriter) WriteString(s string) (int, error) {
+	w.WriteHeaderNow()
+	w.size += len(s)
+	return len(s), nil
+}
+
+func (w *discardWriter) Status() int {
+	return w.status
+}
+
+func (w *discardWriter) Size() int {
+	return w.size
+}
+
+func (w *discardWriter) Written() bool {
+	return w.size != -1
+}
+
+func (w *discardWriter) Flush() {}
+
+func (w *discardWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("cache: a background refresh has no connection")
+}
+
+func (w *discardWriter) CloseNotify() <-chan bool {
+	return nil
+}
+
+func (w *discardWriter) Pusher() http.Pusher {
+	return nil
+}
@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoalescing(t *testing.T) {
+	store := newMemoryStore()
+	var calls int32
+	release := make(chan struct{})
+	r := gin.New()
+	r.GET("/hot", CachePage(store, time.Minute, func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		c.String(http.StatusOK, "hot")
+	}, WithCoalescing()))
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 10)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bodies[i] = performRequest(r, http.MethodGet, "/hot").Body.String()
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for _, body := range bodies {
+		assert.Equal(t, "hot", body)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	store := newMemoryStore()
+	var calls int32
+	release := make(chan struct{})
+	r := gin.New()
+	r.GET("/stale", CachePage(store, 10*time.Millisecond, func(c *gin.Context) {
+		n := atomic.AddInt32(&calls, 1)
+		if n > 1 {
+			<-release
+		}
+		c.String(http.StatusOK, fmt.Sprintf("v%d", n))
+	}, WithStaleWhileRevalidate(time.Minute)))
+
+	assert.Equal(t, "v1", performRequest(r, http.MethodGet, "/stale").Body.String())
+	time.Sleep(20 * time.Millisecond)
+
+	// the request starting the refresh gets the stale copy too, without waiting for it
+	assert.Equal(t, "v1", performRequest(r, http.MethodGet, "/stale").Body.String())
+	assert.Equal(t, "v1", performRequest(r, http.MethodGet, "/stale").Body.String())
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		return performRequest(r, http.MethodGet, "/stale").Body.String() == "v2"
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestRefreshContext(t *testing.T) {
+	store := newMemoryStore()
+	var calls int32
+	type result struct {
+		value       interface{}
+		err         error
+		hasDeadline bool
+	}
+	refreshed := make(chan result, 1)
+	r := gin.New()
+	pages := New(WithCache(store), WithExpire(10*time.Millisecond), WithStaleWhileRevalidate(time.Minute), WithRefreshTimeout(time.Second))
+	r.Use(func(c *gin.Context) {
+		c.Set("user", "alice")
+	})
+	r.GET("/items/:id", pages.Page(func(c *gin.Context) {
+		if atomic.AddInt32(&calls, 1) > 1 {
+			// the request has been served by now
+			time.Sleep(20 * time.Millisecond)
+			_, hasDeadline := c.Request.Context().Deadline()
+			refreshed <- result{c.MustGet("user"), c.Request.Context().Err(), hasDeadline}
+		}
+		c.String(http.StatusOK, c.Param("id"))
+	}))
+
+	performRequest(r, http.MethodGet, "/items/1")
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, "1", performRequest(r, http.MethodGet, "/items/1").Body.String())
+
+	select {
+	case res := <-refreshed:
+		assert.Equal(t, "alice", res.value)
+		assert.NoError(t, res.err)
+		assert.True(t, res.hasDeadline)
+	case <-time.After(time.Second):
+		t.Fatal("page not refreshed")
+	}
+}
+
+func TestStaleWhileRevalidateSite(t *testing.T) {
+	store := newMemoryStore()
+	var calls int32
+	r := gin.New()
+	r.Use(SiteCache(store, 10*time.Millisecond, WithStaleWhileRevalidate(time.Minute)))
+	r.GET("/site", func(c *gin.Context) {
+		c.String(http.StatusOK, fmt.Sprintf("v%d", atomic.AddInt32(&calls, 1)))
+	})
+
+	assert.Equal(t, "v1", performRequest(r, http.MethodGet, "/site").Body.String())
+	time.Sleep(20 * time.Millisecond)
+
+	// the rest of the chain only runs with the request, so the one refreshing the page waits for it
+	assert.Equal(t, "v2", performRequest(r, http.MethodGet, "/site").Body.String())
+	assert.Equal(t, "v2", performRequest(r, http.MethodGet, "/site").Body.String())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestCoalescingWaitTimeout(t *testing.T) {
+	store := newMemoryStore()
+	var calls int32
+	release := make(chan struct{})
+	defer close(release)
+	r := gin.New()
+	r.GET("/hung", CachePage(store, time.Minute, func(c *gin.Context) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-release
+		}
+		c.String(http.StatusOK, "ok")
+	}, WithCoalescing(), WithRefreshTimeout(20*time.Millisecond)))
+
+	go performRequest(r, http.MethodGet, "/hung")
+	time.Sleep(10 * time.Millisecond)
+
+	start := time.Now()
+	assert.Equal(t, "ok", performRequest(r, http.MethodGet, "/hung").Body.String())
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestStaleIfError(t *testing.T) {
+	store := newMemoryStore()
+	calls := 0
+	r := gin.New()
+	r.GET("/error", CachePage(store, 10*time.Millisecond, func(c *gin.Context) {
+		calls++
+		if calls > 1 {
+			c.Header("X-Error", "true")
+			c.String(http.StatusInternalServerError, "error")
+			return
+		}
+		c.String(http.StatusOK, "ok")
+	}, WithStaleIfError(time.Minute)))
+
+	performRequest(r, http.MethodGet, "/error")
+	time.Sleep(20 * time.Millisecond)
+	w := performRequest(r, http.MethodGet, "/error")
+
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ok", w.Body.String())
+	assert.Empty(t, w.Header().Get("X-Error"))
+}
@@ -2,6 +2,7 @@ package gintrace
 
 import (
 	"fmt"
+	"github.com/donetkit/contrib-gin/middleware/recovery"
 	"github.com/donetkit/contrib/tracer"
 	"github.com/gin-gonic/gin"
 	"go.opentelemetry.io/otel/codes"
@@ -82,13 +83,25 @@ func New(opts ...Option) gin.HandlerFunc {
 		}
 		// pass the span through the request context
 		c.Request = c.Request.WithContext(ctx)
+		// a panic unwinding the middleware was not recovered by the recovery middleware,
+		// which records it on the span
+		done := false
+		defer func() {
+			if !done {
+				span.SetStatus(codes.Error, "panic")
+			}
+		}()
 		// serve the request to the next middleware
 		c.Next()
+		done = true
 		status := c.Writer.Status()
 		attrs := semconv.HTTPAttributesFromHTTPStatusCode(status)
 		spanStatus, spanMessage := semconv.SpanStatusFromHTTPStatusCode(status)
 		span.SetAttributes(attrs...)
-		span.SetStatus(spanStatus, spanMessage)
+		if _, ok := recovery.Recovered(c); !ok {
+			// else the status set by the recovery describes the panic
+			span.SetStatus(spanStatus, spanMessage)
+		}
 		if len(c.Errors) > 0 {
 			span.SetAttributes(attribute.String("gin.errors", c.Errors.String()))
 		}
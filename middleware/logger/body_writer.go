@@ -1,16 +1,123 @@
 package logger
 
 import (
-	"bytes"
+	"io"
+	"net/http"
+
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	// requestCaptureLimit and responseCaptureLimit are the number of body bytes kept for the log.
+	requestCaptureLimit  = 1024 * 1024
+	responseCaptureLimit = 1024 * 1024 * 2
+)
+
+// captureBuffer keeps the first limit bytes written to it and counts the others,
+// so large bodies are logged truncated without being buffered.
+type captureBuffer struct {
+	limit     int
+	buf       []byte
+	total     int64
+	truncated bool
+}
+
+func newCaptureBuffer(limit int) *captureBuffer {
+	return &captureBuffer{limit: limit}
+}
+
+// Write implements io.Writer, it never fails.
+func (b *captureBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p[:b.keep(len(p))]...)
+	return len(p), nil
+}
+
+// WriteString implements io.StringWriter, it never fails.
+func (b *captureBuffer) WriteString(s string) (int, error) {
+	b.buf = append(b.buf, s[:b.keep(len(s))]...)
+	return len(s), nil
+}
+
+// keep counts n written bytes and returns how many of them fit in the buffer.
+func (b *captureBuffer) keep(n int) int {
+	b.total += int64(n)
+	room := b.limit - len(b.buf)
+	if room >= n {
+		return n
+	}
+	b.truncated = true
+	if room < 0 {
+		return 0
+	}
+	return room
+}
+
+// skip counts n written bytes without keeping them.
+func (b *captureBuffer) skip(n int) {
+	b.total += int64(n)
+}
+
+// Bytes returns the captured bytes.
+func (b *captureBuffer) Bytes() []byte {
+	return b.buf
+}
+
+// teeBody captures the request body while the handlers read it.
+type teeBody struct {
+	io.ReadCloser
+	capture *captureBuffer
+}
+
+// newTeeBody captures the body of the request, or returns nil when it has none.
+func newTeeBody(req *http.Request) *teeBody {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	body := &teeBody{ReadCloser: req.Body, capture: newCaptureBuffer(requestCaptureLimit)}
+	req.Body = body
+	return body
+}
+
+func (r *teeBody) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.capture.Write(p[:n])
+	}
+	return n, err
+}
+
+// bodyWriter captures the response body while passing it through. Whether it is captured
+// is decided on the first write, once the content type is known.
 type bodyWriter struct {
 	gin.ResponseWriter
-	body *bytes.Buffer
+	body    *captureBuffer
+	capture func() bool
+	decided bool
+	enabled bool
 }
 
-func (r bodyWriter) Write(b []byte) (int, error) {
-	r.body.Write(b)
+func (r *bodyWriter) Write(b []byte) (int, error) {
+	if r.captured() {
+		r.body.Write(b)
+	} else {
+		r.body.skip(len(b))
+	}
 	return r.ResponseWriter.Write(b)
 }
+
+func (r *bodyWriter) WriteString(s string) (int, error) {
+	if r.captured() {
+		r.body.WriteString(s)
+	} else {
+		r.body.skip(len(s))
+	}
+	return r.ResponseWriter.WriteString(s)
+}
+
+func (r *bodyWriter) captured() bool {
+	if !r.decided {
+		r.decided = true
+		r.enabled = r.capture == nil || r.capture()
+	}
+	return r.enabled
+}
@@ -1,17 +1,15 @@
 package logger
 
 import (
-	"bytes"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"io"
+	"github.com/sirupsen/logrus"
 	"net/http"
 	"regexp"
-	"runtime/debug"
 	"time"
-)
 
-var cfg *config
+	"github.com/donetkit/contrib-gin/middleware/recovery"
+)
 
 type consoleColorModeValue int
 
@@ -34,8 +32,6 @@ const (
 	reset   = "\033[0m"
 )
 
-var consoleColorMode = autoColor
-
 // LogFormatter gives the signature of the formatter function passed to LoggerWithFormatter
 type LogFormatter func(params LogFormatterParams) string
 
@@ -51,17 +47,35 @@ type LogFormatterParams struct {
 	ClientIP string
 	// Method is the HTTP method given to the request.
 	Method string
-	// Path is a path the client requests.
+	// Path is a path the client requests, with the query.
 	Path string
+	// Query is the raw query of the request.
+	Query string
+	// Route is the full path of the matched route, e.g. "/users/:id", empty when no route matched.
+	Route string
 	// ErrorMessage is set if error has occurred in processing the request.
 	ErrorMessage string
 	// isTerm shows whether does gin's output descriptor refers to a terminal.
 	isTerm bool
+	// colorMode is the console color mode of the logger.
+	colorMode consoleColorModeValue
+	// recovered is set when the handler panicked.
+	recovered bool
+	// Stack is the stack of the panic of the handler, starting at the frame that panicked.
+	Stack string
 	// BodySize is the size of the Response Body
 	BodySize int
+	// RequestSize is the size of the request body, as read or as announced by Content-Length.
+	RequestSize int64
+	// RequestTruncated and ResponseTruncated are set when only the beginning of the body was captured.
+	RequestTruncated  bool
+	ResponseTruncated bool
 	// Keys are the keys set on the request's context.
 	Keys map[string]interface{}
 
+	// RequestHeader is a copy of the request headers, with the redacted headers masked.
+	RequestHeader http.Header
+	// RequestData and ResponseData are the redacted bodies, or a placeholder when they may not be logged.
 	RequestData      string
 	RequestUserAgent string
 	RequestReferer   string
@@ -119,7 +133,7 @@ func (p *LogFormatterParams) ResetColor() string {
 
 // IsOutputColor indicates whether can colors be outputted to the log.
 func (p *LogFormatterParams) IsOutputColor() bool {
-	return consoleColorMode == forceColor || (consoleColorMode == autoColor && p.isTerm)
+	return p.colorMode == forceColor || (p.colorMode == autoColor && p.isTerm)
 }
 
 // defaultLogFormatter is the default log format function Logger middleware uses.
@@ -147,226 +161,248 @@ var defaultLogFormatter = func(param LogFormatterParams) string {
 	)
 }
 
-// disableConsoleColor disables color output in the console serve.
-func disableConsoleColor() {
-	consoleColorMode = disableColor
-}
-
-// forceConsoleColor force color output in the console serve.
-func forceConsoleColor() {
-	consoleColorMode = forceColor
-}
-
 // NewErrorLogger returns a handler func for any error type.
 func NewErrorLogger(opts ...Option) gin.HandlerFunc {
-	if cfg == nil {
-		cfg = &config{
-			consoleColor: true,
-			endpointLabelMappingFn: func(c *gin.Context) string {
-				return c.Request.URL.Path
-			}}
-	}
-	for _, opt := range opts {
-		opt(cfg)
-	}
-	if cfg.formatter == nil {
-		cfg.formatter = defaultLogFormatter
-	}
-	if cfg.consoleColor {
-		forceConsoleColor()
-	} else {
-		disableConsoleColor()
-	}
-	return ErrorLoggerT(gin.ErrorTypeAny)
+	return ErrorLoggerT(gin.ErrorTypeAny, opts...)
 }
 
 // ErrorLoggerT returns a handler func for a given error type.
-func ErrorLoggerT(typ gin.ErrorType) gin.HandlerFunc {
-	isTerm := true
+// It recovers the panics of the next handlers, see recovery.NewWithOptions, logs them once
+// with the request and renders the response of WithWriterErrorFn.
+func ErrorLoggerT(typ gin.ErrorType, opts ...Option) gin.HandlerFunc {
+	cfg := newConfig(opts...)
+	// the response is rendered below, with the params of the request
+	recoverer := recovery.NewWithOptions(nil, recovery.WithRenderer(func(*gin.Context, error) {}))
 	return func(c *gin.Context) {
-		defer func() {
-			if errRecover := recover(); errRecover != nil {
-				if cfg.logger == nil {
-					return
-				}
-				var recoverErr = fmt.Sprintf("%s", errRecover)
-				cfg.logger.Error(string(debug.Stack()))
-				start := time.Now() // Start timer
-				method := c.Request.Method
-				endpoint := cfg.endpointLabelMappingFn(c)
-				isOk := cfg.checkLabel(fmt.Sprintf("%d", c.Writer.Status()), cfg.excludeRegexStatus) && cfg.checkLabel(endpoint, cfg.excludeRegexEndpoint) && cfg.checkLabel(method, cfg.excludeRegexMethod)
-				if !isOk {
-					return
-				}
-				rawData, err := c.GetRawData()
-				if err == nil {
-					c.Request.Body = io.NopCloser(bytes.NewBuffer(rawData))
-				}
-				raw := c.Request.URL.RawQuery
-				param := LogFormatterParams{
-					isTerm: isTerm,
-					Keys:   c.Keys,
-				}
-				// Stop timer
-				param.ClientIP = c.ClientIP()
-				param.Method = method
-				param.StatusCode = c.Writer.Status()
-				param.BodySize = c.Writer.Size()
-				if raw != "" {
-					endpoint = endpoint + "?" + raw
-				}
-				param.Path = endpoint
-				param.TimeStamp = time.Now()
-				param.Latency = param.TimeStamp.Sub(start)
-				param.ErrorMessage = recoverErr
-				param.RequestProto = c.Request.Proto
-				param.RequestUserAgent = c.Request.UserAgent()
-				param.RequestReferer = c.Request.Referer()
-				param.RequestId = c.Request.Header.Get("X-Request-Id")
-				param.TraceId = c.Request.Header.Get("trace-id")
-				param.SpanId = c.Request.Header.Get("span-id")
-
-				writer := &bodyWriter{body: bytes.NewBufferString(""), ResponseWriter: c.Writer}
-				c.Writer = writer
-
-				if len(rawData) <= 1024*1024 {
-					param.RequestData = string(rawData)
-				} else {
-					param.RequestData = fmt.Sprintf("request data is too large, limit size: %d", 1024*1024)
-				}
-
-				if writer.body.Len() <= 1024*1024*2 {
-					param.ResponseData = writer.body.String()
-				} else {
-					param.ResponseData = fmt.Sprintf("response data is too large, limit size: %d", 1024*1024*2)
-				}
-
-				cfg.logger.Debug(param.RequestData)
-				cfg.logger.Debug(param.ResponseData)
-
-				cfg.logger.Infof("%s", cfg.formatter(param))
-
-				if cfg.writerErrorFn != nil {
-					code, msg := cfg.writerErrorFn(c, &param)
-					c.JSON(code, msg)
-					c.Abort()
-					return
-				}
+		start := time.Now() // Start timer
+		var body *teeBody
+		var writer *bodyWriter
+		if cfg.logger != nil {
+			if cfg.captureRequestBody(c) {
+				body = newTeeBody(c.Request)
+			}
+			writer = cfg.newBodyWriter(c)
+			c.Writer = writer
+		}
+		recovery.DeferLogging(c)
+		recoverer(c)
+		p, ok := recovery.Recovered(c)
+		if !ok || p.Logged || cfg.logger == nil {
+			return
+		}
+		param, included := cfg.params(c, start, body)
+		cfg.recovered(&param, p)
+		if !p.BrokenPipe && !c.Writer.Written() {
+			if cfg.writerErrorFn != nil {
+				code, msg := cfg.writerErrorFn(c, &param)
+				c.JSON(code, msg)
+			} else {
 				c.JSON(-1, param.ErrorMessage)
-				c.Abort()
 			}
-		}()
-		c.Next()
-
+		}
+		param.StatusCode = c.Writer.Status()
+		param.BodySize = c.Writer.Size()
+		if included {
+			cfg.responseData(c, writer, &param)
+			cfg.log(param)
+		}
 	}
 }
 
 // New instances a Logger middleware that will write the logs to gin.DefaultWriter. By default gin.DefaultWriter = os.Stdout.
+// Every call owns its configuration, so several loggers with different options can be used.
+// The panics recovered by the recovery middleware are logged with their request, instead of by it.
 func New(opts ...Option) gin.HandlerFunc {
-	if cfg == nil {
-		cfg = &config{
-			consoleColor: true,
-			endpointLabelMappingFn: func(c *gin.Context) string {
-				return c.Request.URL.Path
-			}}
-	}
-	for _, opt := range opts {
-		opt(cfg)
-	}
-	if cfg.formatter == nil {
-		cfg.formatter = defaultLogFormatter
-	}
-	if cfg.consoleColor {
-		forceConsoleColor()
-	} else {
-		disableConsoleColor()
-	}
-	isTerm := true
+	cfg := newConfig(opts...)
 	//gin.DefaultWriter = &writeLogger{pool: buffer.Pool{}}
 	return func(c *gin.Context) {
 		if cfg.logger == nil {
 			return
 		}
 		start := time.Now() // Start timer
-		method := c.Request.Method
-		endpoint := cfg.endpointLabelMappingFn(c)
-		isOk := cfg.checkLabel(fmt.Sprintf("%d", c.Writer.Status()), cfg.excludeRegexStatus) && cfg.checkLabel(endpoint, cfg.excludeRegexEndpoint) && cfg.checkLabel(method, cfg.excludeRegexMethod)
-		if !isOk {
+		if !cfg.included(c) {
 			return
 		}
-		rawData, err := c.GetRawData()
-		if err == nil {
-			c.Request.Body = io.NopCloser(bytes.NewBuffer(rawData))
+		var body *teeBody
+		if cfg.captureRequestBody(c) {
+			body = newTeeBody(c.Request)
 		}
-		writer := &bodyWriter{body: bytes.NewBufferString(""), ResponseWriter: c.Writer}
+		writer := cfg.newBodyWriter(c)
 		c.Writer = writer
+		// a panic not recovered below unwinds this middleware without logging it
+		restore := recovery.DeferLogging(c)
+		done := false
+		defer func() {
+			if !done {
+				restore()
+			}
+		}()
 		// Process request
 		c.Next()
-		raw := c.Request.URL.RawQuery
-		param := LogFormatterParams{
-			isTerm: isTerm,
-			Keys:   c.Keys,
-		}
-		// Stop timer
-		param.ClientIP = c.ClientIP()
-		param.Method = method
-		param.StatusCode = c.Writer.Status()
-		param.BodySize = c.Writer.Size()
-		if raw != "" {
-			endpoint = endpoint + "?" + raw
-		}
-		param.Path = endpoint
-		param.TimeStamp = time.Now()
-		param.Latency = param.TimeStamp.Sub(start)
+		done = true
+		param, _ := cfg.params(c, start, body)
 		param.ErrorMessage = c.Errors.ByType(gin.ErrorTypePrivate).String()
-
-		if len(rawData) <= 1024*1024 {
-			param.RequestData = string(rawData)
-		} else {
-			param.RequestData = fmt.Sprintf("request data is too large, limit size: %d", 1024*1024)
+		if p, ok := recovery.Recovered(c); ok && !p.Logged {
+			cfg.recovered(&param, p)
 		}
+		cfg.responseData(c, writer, &param)
+
+		cfg.log(param)
 
-		if writer.body.Len() <= 1024*1024*2 {
-			param.ResponseData = writer.body.String()
-		} else {
-			param.ResponseData = fmt.Sprintf("response data is too large, limit size: %d", 1024*1024*2)
+		if cfg.writerLogFn != nil {
+			cfg.writerLogFn(c, &param)
 		}
 
-		cfg.logger.Debug(param.RequestData)
-		cfg.logger.Debug(param.ResponseData)
+	}
+}
 
-		cfg.logger.Infof("%s", cfg.formatter(param))
+// included reports whether the request is logged, according to the exclude patterns.
+func (c *config) included(ctx *gin.Context) bool {
+	return checkLabel(fmt.Sprintf("%d", ctx.Writer.Status()), c.excludeStatus) && checkLabel(c.endpointLabelMappingFn(ctx), c.excludeEndpoint) && checkLabel(ctx.Request.Method, c.excludeMethod)
+}
 
-		if cfg.writerLogFn != nil {
-			param.RequestProto = c.Request.Proto
-			param.RequestUserAgent = c.Request.UserAgent()
-			param.RequestReferer = c.Request.Referer()
-			param.RequestId = c.Request.Header.Get("X-Request-Id")
-			param.TraceId = c.Request.Header.Get("trace-id")
-			param.SpanId = c.Request.Header.Get("span-id")
-			cfg.writerLogFn(c, &param)
+// params returns the params of the request served since start, but its response body,
+// and whether the request is logged.
+func (c *config) params(ctx *gin.Context, start time.Time, body *teeBody) (LogFormatterParams, bool) {
+	endpoint := c.redactText(c.endpointLabelMappingFn(ctx))
+	raw := c.redactQuery(ctx.Request.URL.RawQuery)
+	param := LogFormatterParams{
+		isTerm:    true,
+		colorMode: c.colorMode,
+		Keys:      ctx.Keys,
+	}
+	// Stop timer
+	param.TimeStamp = time.Now()
+	param.Latency = param.TimeStamp.Sub(start)
+	param.ClientIP = ctx.ClientIP()
+	param.Method = ctx.Request.Method
+	param.StatusCode = ctx.Writer.Status()
+	param.BodySize = ctx.Writer.Size()
+	if raw != "" {
+		endpoint = endpoint + "?" + raw
+	}
+	param.Path = endpoint
+	param.Query = raw
+	param.Route = ctx.FullPath()
+
+	param.RequestHeader = c.redactHeader(ctx.Request.Header)
+	c.requestData(ctx, body, &param)
+
+	param.RequestProto = ctx.Request.Proto
+	param.RequestUserAgent = ctx.Request.UserAgent()
+	param.RequestReferer = c.redactURL(ctx.Request.Referer())
+	correlationIds(ctx, &param)
+	return param, c.included(ctx)
+}
+
+// recovered sets the panic of the request on param, it is logged there only.
+func (c *config) recovered(param *LogFormatterParams, p *recovery.Panic) {
+	p.Logged = true
+	param.recovered = true
+	param.ErrorMessage = p.Err.Error()
+	param.Stack = string(p.Stack)
+}
+
+// log writes the record of a request, as the line of the formatter with the bodies logged
+// separately at debug level, or as a single structured record when a schema is set.
+// The level and whether the record is written at all follow the log policy, see level.
+func (c *config) log(param LogFormatterParams) {
+	level, ok := c.level(param)
+	if !ok {
+		return
+	}
+	var suppressed int
+	if c.errorLimiter != nil && level == logrus.ErrorLevel {
+		if suppressed, ok = c.errorLimiter.allow(errorLineKey(param)); !ok {
+			return
 		}
+	}
+	if c.schema != nil {
+		c.logStructured(level, param, suppressed)
+		return
+	}
+	c.logger.Debug(param.RequestData)
+	c.logger.Debug(param.ResponseData)
+
+	msg := c.formatter(param)
+	if suppressed > 0 {
+		msg += fmt.Sprintf(" (%d identical lines suppressed)", suppressed)
+	}
+	if param.Stack != "" {
+		msg += "\n" + param.Stack
+	}
+	logAt(c.logger, level, msg)
+}
 
+// newBodyWriter wraps the writer of ctx to capture the loggable response bodies.
+func (c *config) newBodyWriter(ctx *gin.Context) *bodyWriter {
+	return &bodyWriter{
+		ResponseWriter: ctx.Writer,
+		body:           newCaptureBuffer(responseCaptureLimit),
+		capture: func() bool {
+			return c.captureResponseBody(ctx)
+		},
 	}
 }
 
-// checkLabel returns the match result of labels.
-// Return true if regex-pattern compiles failed.
-func (c *config) checkLabel(label string, patterns []string) bool {
-	if len(patterns) <= 0 {
-		return true
+// requestData sets the request body to log, body is nil when it was not captured.
+// Only what the handlers read is logged, the body is truncated when they left some unread.
+func (c *config) requestData(ctx *gin.Context, body *teeBody, param *LogFormatterParams) {
+	param.RequestSize = ctx.Request.ContentLength
+	if body == nil {
+		param.RequestData = omittedBody(ctx.ContentType(), ctx.Request.ContentLength)
+		return
 	}
+	if body.capture.total > param.RequestSize {
+		param.RequestSize = body.capture.total
+	}
+	param.RequestTruncated = body.capture.truncated || body.capture.total < param.RequestSize
+	param.RequestData = c.capturedBody(ctx.ContentType(), body.capture.Bytes(), param.RequestTruncated, param.RequestSize)
+}
+
+// responseData sets the response body to log.
+func (c *config) responseData(ctx *gin.Context, writer *bodyWriter, param *LogFormatterParams) {
+	contentType := ctx.Writer.Header().Get("Content-Type")
+	if !writer.captured() {
+		param.ResponseData = omittedBody(contentType, writer.body.total)
+		return
+	}
+	param.ResponseTruncated = writer.body.truncated
+	param.ResponseData = c.capturedBody(contentType, writer.body.Bytes(), writer.body.truncated, writer.body.total)
+}
+
+// capturedBody returns the redacted body of size bytes. A truncated JSON body cannot be parsed,
+// so it is omitted when fields have to be redacted from it.
+func (c *config) capturedBody(contentType string, body []byte, truncated bool, size int64) string {
+	if truncated && len(c.redactRules) > 0 && isJSON(contentType) {
+		return fmt.Sprintf("[body truncated: %d bytes of %s]", size, contentType)
+	}
+	return c.redactBody(contentType, body)
+}
+
+// checkLabel returns the match result of labels, false when one of patterns matches.
+func checkLabel(label string, patterns []*regexp.Regexp) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+// compileLabels compiles the exclude patterns. Like when they were matched one by one,
+// an empty or invalid pattern disables itself and the patterns after it.
+func compileLabels(patterns []string) []*regexp.Regexp {
+	var list []*regexp.Regexp
 	for _, pattern := range patterns {
 		if pattern == "" {
-			return true
+			break
 		}
-		matched, err := regexp.MatchString(pattern, label)
+		re, err := regexp.Compile(pattern)
 		if err != nil {
-			return true
-		}
-		if matched {
-			return false
+			break
 		}
+		list = append(list, re)
 	}
-	return true
+	return list
 }
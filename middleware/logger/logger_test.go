@@ -0,0 +1,494 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donetkit/contrib-gin/middleware/recovery"
+	"github.com/donetkit/contrib-gin/middleware/requestid"
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// testLogger is a glog.ILogger writing JSON records to out.
+type testLogger struct {
+	*logrus.Logger
+}
+
+func (testLogger) WithClassName(string) {}
+
+func (l testLogger) SetLevel(level glog.Level) {
+	l.Logger.SetLevel(logrus.Level(level))
+}
+
+func newTestLogger(out *bytes.Buffer, level logrus.Level) testLogger {
+	l := logrus.New()
+	l.SetOutput(out)
+	l.SetFormatter(&logrus.JSONFormatter{})
+	l.SetLevel(level)
+	return testLogger{l}
+}
+
+// records returns the JSON records written to out.
+func records(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	var list []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		list = append(list, record)
+	}
+	return list
+}
+
+func performRequest(r http.Handler, method, target, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+const ok = "ok"
+
+func newTestRouter(opts ...Option) *gin.Engine {
+	r := gin.New()
+	r.Use(New(opts...))
+	r.POST("/users/:id", func(c *gin.Context) {
+		io.Copy(io.Discard, c.Request.Body)
+		c.String(http.StatusCreated, "created")
+	})
+	return r
+}
+
+func TestSchemaECS(t *testing.T) {
+	var out bytes.Buffer
+	r := newTestRouter(WithLogger(newTestLogger(&out, logrus.InfoLevel)), WithSchema(ECSSchema))
+	performRequest(r, http.MethodPost, "/users/42?verbose=1", `{"name":"alice"}`, http.Header{"X-Request-Id": {"rid-1"}})
+
+	list := records(t, &out)
+	if assert.Len(t, list, 1) {
+		record := list[0]
+		assert.Equal(t, "POST /users/42?verbose=1 201", record["msg"])
+		assert.Equal(t, "POST", record["http.request.method"])
+		assert.Equal(t, float64(201), record["http.response.status_code"])
+		assert.Equal(t, float64(7), record["http.response.body.bytes"])
+		assert.Equal(t, "/users/42", record["url.path"])
+		assert.Equal(t, "verbose=1", record["url.query"])
+		assert.Equal(t, "/users/:id", record["http.route"])
+		assert.Equal(t, "rid-1", record["http.request.id"])
+		assert.Contains(t, record, "event.duration")
+		assert.NotContains(t, record, "http.request.body.content")
+	}
+}
+
+func TestSchemaOTelBodiesAtDebug(t *testing.T) {
+	var out bytes.Buffer
+	r := newTestRouter(WithLogger(newTestLogger(&out, logrus.DebugLevel)), WithSchema(OTelSchema))
+	performRequest(r, http.MethodPost, "/users/42", `{"name":"alice"}`, http.Header{"Content-Type": {"application/json"}})
+
+	list := records(t, &out)
+	if assert.Len(t, list, 1, "one record per request") {
+		record := list[0]
+		assert.Equal(t, float64(201), record["http.response.status_code"])
+		assert.Equal(t, "/users/:id", record["http.route"])
+		assert.Equal(t, `{"name":"alice"}`, record["http.request.body.content"])
+		assert.Equal(t, "created", record["http.response.body.content"])
+	}
+}
+
+func TestSchemaApache(t *testing.T) {
+	var out bytes.Buffer
+	r := newTestRouter(WithLogger(newTestLogger(&out, logrus.InfoLevel)), WithSchema(ApacheSchema))
+	performRequest(r, http.MethodPost, "/users/42", "", http.Header{"User-Agent": {"curl/8.0"}})
+
+	list := records(t, &out)
+	if assert.Len(t, list, 1) {
+		record := list[0]
+		assert.Regexp(t, `^192\.0\.2\.1 - - \[.+\] "POST /users/42 HTTP/1\.1" 201 7 "-" "curl/8\.0"$`, record["msg"])
+		assert.Equal(t, float64(201), record["status"])
+		assert.Equal(t, "/users/:id", record["route"])
+		assert.Equal(t, "192.0.2.1", record["client_ip"])
+		assert.Contains(t, record, "latency_ms")
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	var out bytes.Buffer
+	var logged *LogFormatterParams
+	r := newTestRouter(
+		WithLogger(newTestLogger(&out, logrus.DebugLevel)),
+		WithRedactFields("$.password", "card.number"),
+		WithRedactRegexp(EmailRegexp),
+		WithWriterLogFn(func(c *gin.Context, log *LogFormatterParams) {
+			logged = log
+		}),
+	)
+	body := `{"password":"secret","user":{"password":"kept","email":"alice@example.com"},"cards":[{"card":{"number":"4111111111111111"}}]}`
+	performRequest(r, http.MethodPost, "/users/42", body, http.Header{
+		"Content-Type":  {"application/json"},
+		"Authorization": {"Bearer token"},
+	})
+
+	if assert.NotNil(t, logged) {
+		assert.JSONEq(t, `{"password":"[REDACTED]","user":{"password":"kept","email":"[REDACTED]"},"cards":[{"card":{"number":"[REDACTED]"}}]}`, logged.RequestData)
+		assert.Equal(t, "[REDACTED]", logged.RequestHeader.Get("Authorization"))
+		assert.Equal(t, "application/json", logged.RequestHeader.Get("Content-Type"))
+	}
+	assert.NotContains(t, out.String(), "secret")
+	assert.NotContains(t, out.String(), "4111111111111111")
+
+	performRequest(r, http.MethodPost, "/users/42", "password=secret&card.number=4111&name=alice", http.Header{
+		"Content-Type": {"application/x-www-form-urlencoded"},
+	})
+	assert.Equal(t, "card.number=%5BREDACTED%5D&name=alice&password=%5BREDACTED%5D", logged.RequestData)
+}
+
+func TestQueryAndRefererRedaction(t *testing.T) {
+	var out bytes.Buffer
+	r := newTestRouter(
+		WithLogger(newTestLogger(&out, logrus.InfoLevel)),
+		WithSchema(ECSSchema),
+		WithRedactFields("password"),
+		WithRedactRegexp(EmailRegexp),
+	)
+	performRequest(r, http.MethodPost, "/users/42?password=hunter2&email=alice%40example.com&page=2", "", http.Header{
+		"Referer": {"https://example.com/signup?email=bob@example.com&password=hunter3&ref=home"},
+	})
+
+	list := records(t, &out)
+	if assert.Len(t, list, 1) {
+		record := list[0]
+		assert.Equal(t, "POST /users/42?password=[REDACTED]&email=[REDACTED]&page=2 201", record["msg"])
+		assert.Equal(t, "/users/42", record["url.path"])
+		assert.Equal(t, "password=[REDACTED]&email=[REDACTED]&page=2", record["url.query"])
+		assert.Equal(t, "https://example.com/signup?email=[REDACTED]&password=[REDACTED]&ref=home", record["http.request.referrer"])
+	}
+	for _, secret := range []string{"hunter", "alice", "bob"} {
+		assert.NotContains(t, out.String(), secret)
+	}
+}
+
+func TestBodyCaptureFilters(t *testing.T) {
+	var out bytes.Buffer
+	var logged *LogFormatterParams
+	r := newTestRouter(
+		WithLogger(newTestLogger(&out, logrus.DebugLevel)),
+		WithSkipBodyRoutes("/upload"),
+		WithWriterLogFn(func(c *gin.Context, log *LogFormatterParams) {
+			logged = log
+		}),
+	)
+	r.POST("/upload", func(c *gin.Context) {
+		c.String(http.StatusOK, "stored")
+	})
+
+	performRequest(r, http.MethodPost, "/users/42", "\x89PNG", http.Header{"Content-Type": {"image/png"}})
+	assert.Equal(t, "[body omitted: 4 bytes of image/png]", logged.RequestData)
+	assert.Equal(t, "created", logged.ResponseData)
+
+	performRequest(r, http.MethodPost, "/upload", `{"file":"..."}`, http.Header{"Content-Type": {"application/json"}})
+	assert.Equal(t, "[body omitted: 14 bytes of application/json]", logged.RequestData)
+	assert.Equal(t, "[body omitted: 6 bytes of text/plain; charset=utf-8]", logged.ResponseData)
+}
+
+func levels(t *testing.T, out *bytes.Buffer) []string {
+	var list []string
+	for _, record := range records(t, out) {
+		list = append(list, record["level"].(string))
+	}
+	out.Reset()
+	return list
+}
+
+func TestLevelRoutingAndSampling(t *testing.T) {
+	defer func() { sampleRand = rand.Float64 }()
+	var out bytes.Buffer
+	r := newTestRouter(
+		WithLogger(newTestLogger(&out, logrus.InfoLevel)),
+		WithSchema(ECSSchema),
+		WithLevelRouting(),
+		WithSlowThreshold(20*time.Millisecond),
+		WithRouteSampling(0.01, "/health", "/slow"),
+	)
+	r.GET("/health", func(c *gin.Context) {
+		c.String(http.StatusOK, ok)
+	})
+	r.GET("/slow", func(c *gin.Context) {
+		time.Sleep(30 * time.Millisecond)
+		c.String(http.StatusOK, ok)
+	})
+	r.GET("/fail", func(c *gin.Context) {
+		c.String(http.StatusBadGateway, "fail")
+	})
+
+	performRequest(r, http.MethodGet, "/fail", "", nil)
+	performRequest(r, http.MethodGet, "/missing", "", nil)
+	performRequest(r, http.MethodGet, "/slow", "", nil)
+	assert.Equal(t, []string{"error", "warning", "warning"}, levels(t, &out))
+
+	sampleRand = func() float64 { return 0.5 }
+	performRequest(r, http.MethodGet, "/health", "", nil)
+	performRequest(r, http.MethodPost, "/users/42", "", nil)
+	assert.Equal(t, []string{"info"}, levels(t, &out), "hot routes are sampled")
+
+	sampleRand = func() float64 { return 0.001 }
+	performRequest(r, http.MethodGet, "/health", "", nil)
+	assert.Equal(t, []string{"info"}, levels(t, &out))
+}
+
+func TestErrorRateLimit(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+
+	var out bytes.Buffer
+	r := newTestRouter(
+		WithLogger(newTestLogger(&out, logrus.InfoLevel)),
+		WithSchema(ECSSchema),
+		WithLevelRouting(),
+		WithErrorRateLimit(time.Minute),
+	)
+	r.GET("/fail", func(c *gin.Context) {
+		c.String(http.StatusInternalServerError, "fail")
+	})
+
+	for i := 0; i < 5; i++ {
+		performRequest(r, http.MethodGet, "/fail", "", nil)
+	}
+	assert.Len(t, records(t, &out), 1)
+
+	out.Reset()
+	now = now.Add(time.Minute)
+	performRequest(r, http.MethodGet, "/fail", "", nil)
+	list := records(t, &out)
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, float64(4), list[0]["suppressed"])
+	}
+}
+
+func TestInstancesOwnTheirConfig(t *testing.T) {
+	var first, second bytes.Buffer
+	r := gin.New()
+	r.GET("/first", New(WithLogger(newTestLogger(&first, logrus.InfoLevel)), WithSchema(ECSSchema)), func(c *gin.Context) {
+		c.String(http.StatusOK, ok)
+	})
+	r.GET("/second", New(WithLogger(newTestLogger(&second, logrus.InfoLevel)), WithSchema(OTelSchema), WithExcludeRegexMethod([]string{"^HEAD$"})), func(c *gin.Context) {
+		c.String(http.StatusOK, ok)
+	})
+	performRequest(r, http.MethodGet, "/first", "", nil)
+	performRequest(r, http.MethodGet, "/second", "", nil)
+
+	list := records(t, &first)
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, "/first", list[0]["url.path"])
+		assert.Contains(t, list[0], "client.ip")
+	}
+	list = records(t, &second)
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, "/second", list[0]["url.path"])
+		assert.Contains(t, list[0], "client.address")
+	}
+}
+
+func TestExcludeRegex(t *testing.T) {
+	var out bytes.Buffer
+	r := newTestRouter(WithLogger(newTestLogger(&out, logrus.InfoLevel)), WithSchema(ECSSchema), WithExcludeRegexEndpoint([]string{`^/users/\d+$`}))
+	performRequest(r, http.MethodPost, "/users/42", "", nil)
+	assert.Empty(t, records(t, &out))
+
+	r = newTestRouter(WithLogger(newTestLogger(&out, logrus.InfoLevel)), WithSchema(ECSSchema), WithExcludeRegexEndpoint([]string{`(`, `^/users/\d+$`}))
+	performRequest(r, http.MethodPost, "/users/42", "", nil)
+	assert.Len(t, records(t, &out), 1, "an invalid pattern disables the exclusion")
+}
+
+func TestCaptureBuffer(t *testing.T) {
+	b := newCaptureBuffer(8)
+	b.Write([]byte("hello "))
+	b.WriteString("world")
+	b.Write([]byte("!"))
+	assert.Equal(t, "hello wo", string(b.Bytes()))
+	assert.Equal(t, int64(12), b.total)
+	assert.True(t, b.truncated)
+}
+
+func TestStreamingBodyCapture(t *testing.T) {
+	var out bytes.Buffer
+	var logged *LogFormatterParams
+	r := newTestRouter(
+		WithLogger(newTestLogger(&out, logrus.DebugLevel)),
+		WithRedactFields("password"),
+		WithWriterLogFn(func(c *gin.Context, log *LogFormatterParams) {
+			logged = log
+		}),
+	)
+	chunk := strings.Repeat("x", 64*1024)
+	r.GET("/stream", func(c *gin.Context) {
+		c.Header("Content-Type", "text/event-stream")
+		for i := 0; i < 48; i++ {
+			c.Writer.WriteString(chunk)
+			c.Writer.Flush()
+		}
+	})
+	r.GET("/download", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/octet-stream", []byte(chunk))
+	})
+	r.POST("/echo", func(c *gin.Context) {
+		var v map[string]interface{}
+		if err := c.BindJSON(&v); err == nil {
+			c.JSON(http.StatusOK, v)
+		}
+	})
+
+	w := performRequest(r, http.MethodGet, "/stream", "", nil)
+	assert.Equal(t, 48*len(chunk), w.Body.Len(), "the response passes through untouched")
+	assert.True(t, logged.ResponseTruncated)
+	assert.Len(t, logged.ResponseData, responseCaptureLimit)
+	assert.Equal(t, 48*len(chunk), logged.BodySize)
+
+	performRequest(r, http.MethodGet, "/download", "", nil)
+	assert.Equal(t, "[body omitted: 65536 bytes of application/octet-stream]", logged.ResponseData)
+
+	w = performRequest(r, http.MethodPost, "/echo", `{"password":"secret","name":"alice"}`, http.Header{"Content-Type": {"application/json"}})
+	assert.JSONEq(t, `{"password":"secret","name":"alice"}`, w.Body.String(), "the handler reads the body through the capture")
+	assert.JSONEq(t, `{"password":"[REDACTED]","name":"alice"}`, logged.RequestData)
+	assert.False(t, logged.RequestTruncated)
+
+	large := `{"password":"secret","data":"` + strings.Repeat("x", requestCaptureLimit) + `"}`
+	performRequest(r, http.MethodPost, "/users/42", large, http.Header{"Content-Type": {"application/json"}})
+	assert.True(t, logged.RequestTruncated)
+	assert.Equal(t, int64(len(large)), logged.RequestSize)
+	assert.Equal(t, fmt.Sprintf("[body truncated: %d bytes of application/json]", len(large)), logged.RequestData)
+	assert.NotContains(t, out.String(), "secret")
+}
+
+// countingReader counts the bytes read from it.
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += n
+	return n, err
+}
+
+func TestUnreadBodyNotDrained(t *testing.T) {
+	var out bytes.Buffer
+	var logged *LogFormatterParams
+	r := newTestRouter(
+		WithLogger(newTestLogger(&out, logrus.DebugLevel)),
+		WithWriterLogFn(func(c *gin.Context, log *LogFormatterParams) {
+			logged = log
+		}),
+	)
+	r.POST("/upload", func(c *gin.Context) {
+		head := make([]byte, 5)
+		io.ReadFull(c.Request.Body, head)
+		c.String(http.StatusRequestEntityTooLarge, "too large")
+	})
+
+	body := &countingReader{Reader: strings.NewReader("hello" + strings.Repeat("x", 2*requestCaptureLimit))}
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.ContentLength = int64(5 + 2*requestCaptureLimit)
+	req.Header.Set("Content-Type", "text/plain")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Less(t, body.n, 64*1024, "the logger must not read what the handler left")
+	assert.Equal(t, "hello", logged.RequestData)
+	assert.True(t, logged.RequestTruncated)
+	assert.Equal(t, int64(5+2*requestCaptureLimit), logged.RequestSize)
+}
+
+func TestRequestIdFromContext(t *testing.T) {
+	var out bytes.Buffer
+	r := gin.New()
+	r.Use(requestid.New(requestid.WithCustomHeaderStrKey("X-Correlation-Id")), New(WithLogger(newTestLogger(&out, logrus.InfoLevel)), WithSchema(ECSSchema)))
+	r.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, ok)
+	})
+	performRequest(r, http.MethodGet, "/", "", http.Header{"X-Correlation-Id": {"rid-2"}})
+
+	list := records(t, &out)
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, "rid-2", list[0]["http.request.id"])
+	}
+}
+
+func TestPanicLoggedOnce(t *testing.T) {
+	var out, recoveryOut bytes.Buffer
+	r := gin.New()
+	r.Use(
+		requestid.New(),
+		New(WithLogger(newTestLogger(&out, logrus.DebugLevel)), WithSchema(ECSSchema)),
+		recovery.NewWithOptions(newTestLogger(&recoveryOut, logrus.InfoLevel), recovery.WithRenderer(recovery.ProblemRenderer)),
+	)
+	r.GET("/users/:id", func(c *gin.Context) {
+		panic("oops")
+	})
+	w := performRequest(r, http.MethodGet, "/users/42", "", http.Header{"X-Request-Id": {"rid-3"}})
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Empty(t, recoveryOut.String(), "the access logger logs the panic")
+	list := records(t, &out)
+	if assert.Len(t, list, 1) {
+		record := list[0]
+		assert.Equal(t, "error", record["level"])
+		assert.Equal(t, "oops", record["error.message"])
+		assert.Equal(t, "/users/:id", record["http.route"])
+		assert.Equal(t, "rid-3", record["http.request.id"])
+		assert.Equal(t, float64(500), record["http.response.status_code"])
+		assert.Contains(t, record["http.response.body.content"], "rid-3")
+		assert.Contains(t, record["error.stack_trace"], "logger.TestPanicLoggedOnce")
+	}
+}
+
+func TestErrorLoggerPanic(t *testing.T) {
+	var out bytes.Buffer
+	r := gin.New()
+	r.Use(
+		NewErrorLogger(WithLogger(newTestLogger(&out, logrus.DebugLevel)), WithSchema(ECSSchema), WithWriterErrorFn(func(c *gin.Context, log *LogFormatterParams) (int, interface{}) {
+			return http.StatusInternalServerError, gin.H{"error": log.ErrorMessage}
+		})),
+		New(WithLogger(newTestLogger(&out, logrus.DebugLevel)), WithSchema(ECSSchema)),
+	)
+	r.GET("/", func(c *gin.Context) {
+		time.Sleep(time.Millisecond)
+		panic("oops")
+	})
+	w := performRequest(r, http.MethodGet, "/", "", nil)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.JSONEq(t, `{"error":"oops"}`, w.Body.String())
+	list := records(t, &out)
+	if assert.Len(t, list, 1, "the panic is logged once") {
+		record := list[0]
+		assert.Equal(t, "oops", record["error.message"])
+		assert.JSONEq(t, `{"error":"oops"}`, record["http.response.body.content"].(string))
+		assert.GreaterOrEqual(t, record["event.duration"], float64(time.Millisecond))
+	}
+}
+
+func TestDefaultRedactHeadersIndependent(t *testing.T) {
+	assert.Equal(t, recovery.DefaultRedactHeaders, DefaultRedactHeaders)
+	DefaultRedactHeaders[0] = "X-Other"
+	defer func() { DefaultRedactHeaders[0] = "Authorization" }()
+	assert.Equal(t, "Authorization", recovery.DefaultRedactHeaders[0])
+}
@@ -1,6 +1,9 @@
 package logger
 
 import (
+	"regexp"
+	"time"
+
 	"github.com/donetkit/contrib-log/glog"
 	"github.com/gin-gonic/gin"
 )
@@ -13,15 +16,53 @@ type config struct {
 	excludeRegexStatus     []string
 	excludeRegexEndpoint   []string
 	excludeRegexMethod     []string
+	excludeStatus          []*regexp.Regexp
+	excludeEndpoint        []*regexp.Regexp
+	excludeMethod          []*regexp.Regexp
 	endpointLabelMappingFn RequestLabelMappingFn
 	consoleColor           bool
+	colorMode              consoleColorModeValue
 	writerLogFn            WriterLogFn
 	writerErrorFn          WriterErrorFn
+	schema                 Schema
+	redactRules            []redactRule
+	redactHeaders          []string
+	redactRegexps          []*regexp.Regexp
+	skipBodyRoutes         map[string]struct{}
+	bodyContentTypes       []string
+	levelRouting           bool
+	slowThreshold          time.Duration
+	sampleRoutes           map[string]float64
+	errorLimiter           *rateLimiter
 }
 
 // Option for queue system
 type Option func(*config)
 
+func newConfig(opts ...Option) *config {
+	cfg := &config{
+		consoleColor: true,
+		endpointLabelMappingFn: func(c *gin.Context) string {
+			return c.Request.URL.Path
+		},
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.formatter == nil {
+		cfg.formatter = defaultLogFormatter
+	}
+	if cfg.consoleColor {
+		cfg.colorMode = forceColor
+	} else {
+		cfg.colorMode = disableColor
+	}
+	cfg.excludeStatus = compileLabels(cfg.excludeRegexStatus)
+	cfg.excludeEndpoint = compileLabels(cfg.excludeRegexEndpoint)
+	cfg.excludeMethod = compileLabels(cfg.excludeRegexMethod)
+	return cfg
+}
+
 type WriterLogFn func(c *gin.Context, log *LogFormatterParams)
 
 type WriterErrorFn func(c *gin.Context, log *LogFormatterParams) (int, interface{})
@@ -88,3 +129,92 @@ func WithWriterErrorFn(fn WriterErrorFn) Option {
 		cfg.writerErrorFn = fn
 	}
 }
+
+// WithSchema log one structured record per request, with the fields named after schema,
+// e.g. ECSSchema, OTelSchema or ApacheSchema, instead of the line of the formatter
+func WithSchema(schema Schema) Option {
+	return func(cfg *config) {
+		cfg.schema = schema
+	}
+}
+
+// WithRedactFields mask the body fields matching rules, for JSON and form bodies, and the query
+// parameters of the request URL and Referer named after them. "$.password" only matches at the root,
+// "card.number" matches at any depth, "*" matches any key, arrays are traversed transparently
+func WithRedactFields(rules ...string) Option {
+	return func(cfg *config) {
+		for _, rule := range rules {
+			cfg.redactRules = append(cfg.redactRules, parseRedactRule(rule))
+		}
+	}
+}
+
+// WithRedactHeaders set the headers masked in RequestHeader, default DefaultRedactHeaders
+func WithRedactHeaders(names ...string) Option {
+	return func(cfg *config) {
+		cfg.redactHeaders = names
+	}
+}
+
+// WithRedactRegexp mask the matches of patterns in bodies, headers, the request path and query and
+// the Referer, e.g. EmailRegexp and PhoneRegexp
+func WithRedactRegexp(patterns ...*regexp.Regexp) Option {
+	return func(cfg *config) {
+		cfg.redactRegexps = append(cfg.redactRegexps, patterns...)
+	}
+}
+
+// WithSkipBodyRoutes never capture the bodies of the routes registered as fullPaths, e.g. "/upload/:id"
+func WithSkipBodyRoutes(fullPaths ...string) Option {
+	return func(cfg *config) {
+		if cfg.skipBodyRoutes == nil {
+			cfg.skipBodyRoutes = make(map[string]struct{})
+		}
+		for _, path := range fullPaths {
+			cfg.skipBodyRoutes[path] = struct{}{}
+		}
+	}
+}
+
+// WithBodyContentTypes set the content types whose bodies are captured, "text/*" and "*/*+json"
+// patterns are supported, default DefaultBodyContentTypes
+func WithBodyContentTypes(types ...string) Option {
+	return func(cfg *config) {
+		cfg.bodyContentTypes = types
+	}
+}
+
+// WithLevelRouting log 5xx responses and panics at Error and 4xx responses at Warn instead of Info
+func WithLevelRouting() Option {
+	return func(cfg *config) {
+		cfg.levelRouting = true
+	}
+}
+
+// WithSlowThreshold log requests taking at least threshold at Warn
+func WithSlowThreshold(threshold time.Duration) Option {
+	return func(cfg *config) {
+		cfg.slowThreshold = threshold
+	}
+}
+
+// WithRouteSampling log only the given fraction, e.g. 0.01, of the 2xx requests of the routes
+// registered as fullPaths. Requests logged at Warn or Error are never sampled out
+func WithRouteSampling(rate float64, fullPaths ...string) Option {
+	return func(cfg *config) {
+		if cfg.sampleRoutes == nil {
+			cfg.sampleRoutes = make(map[string]float64)
+		}
+		for _, path := range fullPaths {
+			cfg.sampleRoutes[path] = rate
+		}
+	}
+}
+
+// WithErrorRateLimit log identical error lines, same status, method, route and error,
+// at most once per interval, the next line logged tells how many were suppressed
+func WithErrorRateLimit(interval time.Duration) Option {
+	return func(cfg *config) {
+		cfg.errorLimiter = newRateLimiter(interval)
+	}
+}
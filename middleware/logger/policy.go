@@ -0,0 +1,106 @@
+package logger
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/donetkit/contrib-log/glog"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	timeNow    = time.Now
+	sampleRand = rand.Float64
+)
+
+// level returns the level of the record of a request and whether it is logged at all.
+func (c *config) level(param LogFormatterParams) (logrus.Level, bool) {
+	level := logrus.InfoLevel
+	if param.recovered {
+		// a panic is logged once, with the request
+		return logrus.ErrorLevel, true
+	}
+	if c.levelRouting {
+		switch {
+		case param.StatusCode >= http.StatusInternalServerError:
+			level = logrus.ErrorLevel
+		case param.StatusCode >= http.StatusBadRequest:
+			level = logrus.WarnLevel
+		}
+	}
+	if level == logrus.InfoLevel && c.slowThreshold > 0 && param.Latency >= c.slowThreshold {
+		level = logrus.WarnLevel
+	}
+	if level == logrus.InfoLevel && c.sampleRoutes != nil && param.StatusCode >= http.StatusOK && param.StatusCode < http.StatusMultipleChoices {
+		if rate, ok := c.sampleRoutes[param.Route]; ok && sampleRand() >= rate {
+			return level, false
+		}
+	}
+	return level, true
+}
+
+// rateLimiter lets one of identical lines through per interval and counts the others.
+type rateLimiter struct {
+	interval time.Duration
+	mu       sync.Mutex
+	lines    map[string]*limitedLine
+}
+
+type limitedLine struct {
+	until      time.Time
+	suppressed int
+}
+
+func newRateLimiter(interval time.Duration) *rateLimiter {
+	return &rateLimiter{interval: interval, lines: make(map[string]*limitedLine)}
+}
+
+// allow reports whether the line identified by key is logged, with the number of identical
+// lines suppressed since it was last logged.
+func (l *rateLimiter) allow(key string) (int, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := timeNow()
+	if line, ok := l.lines[key]; ok && now.Before(line.until) {
+		line.suppressed++
+		return 0, false
+	}
+	suppressed := 0
+	if line, ok := l.lines[key]; ok {
+		suppressed = line.suppressed
+	}
+	if len(l.lines) >= 1024 {
+		// forget the lines whose interval is over, so the map does not grow forever
+		for k, line := range l.lines {
+			if !now.Before(line.until) {
+				delete(l.lines, k)
+			}
+		}
+	}
+	l.lines[key] = &limitedLine{until: now.Add(l.interval)}
+	return suppressed, true
+}
+
+// errorLineKey identifies identical error lines.
+func errorLineKey(param LogFormatterParams) string {
+	route := param.Route
+	if route == "" {
+		route = param.Path
+	}
+	return fmt.Sprintf("%d %s %s %s", param.StatusCode, param.Method, route, param.ErrorMessage)
+}
+
+// logAt writes msg to logger at level.
+func logAt(logger glog.ILoggerEntry, level logrus.Level, msg string) {
+	switch level {
+	case logrus.ErrorLevel:
+		logger.Errorf("%s", msg)
+	case logrus.WarnLevel:
+		logger.Warnf("%s", msg)
+	default:
+		logger.Infof("%s", msg)
+	}
+}
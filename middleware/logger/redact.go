@@ -0,0 +1,276 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// redacted replaces the values removed by the redaction rules.
+const redacted = "[REDACTED]"
+
+var (
+	// DefaultRedactHeaders are the headers masked when WithRedactHeaders is not given.
+	DefaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+	// DefaultBodyContentTypes are the content types whose bodies are logged when WithBodyContentTypes
+	// is not given, others such as uploads are replaced by a placeholder.
+	DefaultBodyContentTypes = []string{"application/json", "application/x-www-form-urlencoded", "application/xml", "text/*", "*/*+json", "*/*+xml"}
+
+	// EmailRegexp matches email addresses, for WithRedactRegexp.
+	EmailRegexp = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	// PhoneRegexp matches phone numbers of 7 to 15 digits, optionally with a leading + and separators, for WithRedactRegexp.
+	PhoneRegexp = regexp.MustCompile(`\+?\d(?:[ \-.]?\d){6,14}`)
+)
+
+// redactRule is a parsed field rule, anchored rules only match from the root of the document.
+type redactRule struct {
+	path     []string
+	anchored bool
+}
+
+// parseRedactRule parses "$.card.number" or "card.number", "*" matches any key.
+func parseRedactRule(rule string) redactRule {
+	anchored := strings.HasPrefix(rule, "$")
+	rule = strings.TrimPrefix(strings.TrimPrefix(rule, "$"), ".")
+	return redactRule{path: strings.Split(rule, "."), anchored: anchored}
+}
+
+// captureRequestBody reports whether the request body of c may be logged.
+func (c *config) captureRequestBody(ctx *gin.Context) bool {
+	if _, ok := c.skipBodyRoutes[ctx.FullPath()]; ok {
+		return false
+	}
+	return c.loggableContentType(ctx.ContentType())
+}
+
+// captureResponseBody reports whether the response body of c may be logged.
+func (c *config) captureResponseBody(ctx *gin.Context) bool {
+	if _, ok := c.skipBodyRoutes[ctx.FullPath()]; ok {
+		return false
+	}
+	return c.loggableContentType(ctx.Writer.Header().Get("Content-Type"))
+}
+
+func (c *config) loggableContentType(contentType string) bool {
+	if contentType == "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	types := c.bodyContentTypes
+	if types == nil {
+		types = DefaultBodyContentTypes
+	}
+	for _, t := range types {
+		if matchMediaType(t, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchMediaType matches "type/subtype", "type/*" and "*/*+suffix" patterns.
+func matchMediaType(pattern, mediaType string) bool {
+	switch {
+	case pattern == mediaType:
+		return true
+	case strings.HasPrefix(pattern, "*/*+"):
+		return strings.HasSuffix(mediaType, pattern[3:])
+	case strings.HasSuffix(pattern, "/*"):
+		return strings.HasPrefix(mediaType, pattern[:len(pattern)-1])
+	}
+	return false
+}
+
+// omittedBody is logged instead of a body that may not be captured, size is -1 when unknown.
+func omittedBody(contentType string, size int64) string {
+	switch {
+	case size == 0:
+		return ""
+	case size < 0:
+		return fmt.Sprintf("[body omitted: %s]", contentType)
+	}
+	return fmt.Sprintf("[body omitted: %d bytes of %s]", size, contentType)
+}
+
+// redactBody applies the field rules to a JSON or form body and the regexps to any body.
+func (c *config) redactBody(contentType string, body []byte) string {
+	if len(c.redactRules) > 0 {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		switch {
+		case isJSON(contentType):
+			body = c.redactJSON(body)
+		case mediaType == "application/x-www-form-urlencoded":
+			body = c.redactForm(body)
+		}
+	}
+	return c.redactText(string(body))
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func (c *config) redactJSON(body []byte) []byte {
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		// not JSON after all, the regexps still apply
+		return body
+	}
+	for _, rule := range c.redactRules {
+		doc = redactValue(doc, rule.path, rule.anchored)
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return body
+	}
+	return b
+}
+
+// redactValue masks the values at path in v, arrays are traversed transparently.
+// Unanchored paths are also tried from every nested object.
+func redactValue(v interface{}, path []string, anchored bool) interface{} {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		for key, child := range node {
+			if path[0] == "*" || path[0] == key {
+				if len(path) == 1 {
+					node[key] = redacted
+					continue
+				}
+				node[key] = redactValue(child, path[1:], true)
+			}
+			if !anchored {
+				node[key] = redactValue(node[key], path, false)
+			}
+		}
+	case []interface{}:
+		for i, child := range node {
+			node[i] = redactValue(child, path, anchored)
+		}
+	}
+	return v
+}
+
+// redactForm masks the form fields named after a rule, e.g. "password" or "card.number".
+func (c *config) redactForm(body []byte) []byte {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return body
+	}
+	for key := range values {
+		if c.redactedField(key) {
+			values[key] = []string{redacted}
+		}
+	}
+	return []byte(values.Encode())
+}
+
+// redactedField reports whether the form field or query parameter key is named after a rule.
+func (c *config) redactedField(key string) bool {
+	for _, rule := range c.redactRules {
+		name := strings.Join(rule.path, ".")
+		if key == name || (!rule.anchored && strings.HasSuffix(key, "."+name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactQuery masks the query parameters named after a rule and applies the regexps to the
+// unescaped names and values, keeping the order of the parameters.
+func (c *config) redactQuery(raw string) string {
+	if raw == "" || (len(c.redactRules) == 0 && len(c.redactRegexps) == 0) {
+		return raw
+	}
+	pairs := strings.Split(raw, "&")
+	for i, pair := range pairs {
+		key, value, hasValue := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			pairs[i] = c.redactText(pair)
+			continue
+		}
+		key = c.redactQueryComponent(key, name)
+		if c.redactedField(name) {
+			value = redacted
+		} else if unescaped, err := url.QueryUnescape(value); err == nil {
+			value = c.redactQueryComponent(value, unescaped)
+		} else {
+			value = c.redactText(value)
+		}
+		if hasValue {
+			pairs[i] = key + "=" + value
+		} else {
+			pairs[i] = key
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// redactQueryComponent applies the regexps to the unescaped form of the query component raw,
+// which is returned as is when nothing matches.
+func (c *config) redactQueryComponent(raw, unescaped string) string {
+	masked := c.redactText(unescaped)
+	if masked == unescaped {
+		return raw
+	}
+	return strings.ReplaceAll(url.QueryEscape(masked), url.QueryEscape(redacted), redacted)
+}
+
+// redactURL redacts the query of the URL s and applies the regexps to the rest of it.
+func (c *config) redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.RawQuery == "" {
+		return c.redactText(s)
+	}
+	query := c.redactQuery(u.RawQuery)
+	u.RawQuery = ""
+	return c.redactText(u.String()) + "?" + query
+}
+
+func (c *config) redactText(s string) string {
+	for _, re := range c.redactRegexps {
+		s = re.ReplaceAllString(s, redacted)
+	}
+	return s
+}
+
+// redactHeader returns a copy of h with the denied headers and the regexps masked.
+func (c *config) redactHeader(h http.Header) http.Header {
+	denied := c.redactHeaders
+	if denied == nil {
+		denied = DefaultRedactHeaders
+	}
+	out := make(http.Header, len(h))
+	for key, values := range h {
+		out[key] = make([]string, len(values))
+		for i, value := range values {
+			if key == "Referer" {
+				out[key][i] = c.redactURL(value)
+				continue
+			}
+			out[key][i] = c.redactText(value)
+		}
+	}
+	for _, key := range denied {
+		if values, ok := out[http.CanonicalHeaderKey(key)]; ok {
+			for i := range values {
+				values[i] = redacted
+			}
+		}
+	}
+	return out
+}
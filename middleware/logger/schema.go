@@ -0,0 +1,191 @@
+package logger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/donetkit/contrib-gin/middleware/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// Schema maps the params of a request to the message and the typed fields of its structured record.
+type Schema func(params LogFormatterParams) (string, map[string]interface{})
+
+// ECSSchema names the fields after the Elastic Common Schema.
+var ECSSchema Schema = func(p LogFormatterParams) (string, map[string]interface{}) {
+	fields := map[string]interface{}{
+		"http.request.method":       p.Method,
+		"http.response.status_code": p.StatusCode,
+		"http.response.body.bytes":  p.BodySize,
+		"http.version":              strings.TrimPrefix(p.RequestProto, "HTTP/"),
+		"event.duration":            p.Latency.Nanoseconds(),
+		"url.path":                  urlPath(p),
+		"client.ip":                 p.ClientIP,
+	}
+	optional(fields, "url.query", p.Query)
+	optional(fields, "http.route", p.Route)
+	optional(fields, "http.request.id", p.RequestId)
+	optional(fields, "http.request.referrer", p.RequestReferer)
+	optional(fields, "http.request.body.content", p.RequestData)
+	optional(fields, "http.response.body.content", p.ResponseData)
+	size(fields, "http.request.body.bytes", p.RequestSize)
+	flag(fields, "http.request.body.truncated", p.RequestTruncated)
+	flag(fields, "http.response.body.truncated", p.ResponseTruncated)
+	optional(fields, "user_agent.original", p.RequestUserAgent)
+	optional(fields, "trace.id", p.TraceId)
+	optional(fields, "span.id", p.SpanId)
+	optional(fields, "error.message", p.ErrorMessage)
+	optional(fields, "error.stack_trace", p.Stack)
+	return requestLine(p), fields
+}
+
+// OTelSchema names the fields after the OpenTelemetry semantic conventions for HTTP servers.
+var OTelSchema Schema = func(p LogFormatterParams) (string, map[string]interface{}) {
+	fields := map[string]interface{}{
+		"http.request.method":          p.Method,
+		"http.response.status_code":    p.StatusCode,
+		"http.response.body.size":      p.BodySize,
+		"http.server.request.duration": p.Latency.Seconds(),
+		"network.protocol.version":     strings.TrimPrefix(p.RequestProto, "HTTP/"),
+		"url.path":                     urlPath(p),
+		"client.address":               p.ClientIP,
+	}
+	optional(fields, "url.query", p.Query)
+	optional(fields, "http.route", p.Route)
+	optional(fields, "http.request.header.x-request-id", p.RequestId)
+	optional(fields, "http.request.header.referer", p.RequestReferer)
+	optional(fields, "http.request.body.content", p.RequestData)
+	optional(fields, "http.response.body.content", p.ResponseData)
+	size(fields, "http.request.body.size", p.RequestSize)
+	flag(fields, "http.request.body.truncated", p.RequestTruncated)
+	flag(fields, "http.response.body.truncated", p.ResponseTruncated)
+	optional(fields, "user_agent.original", p.RequestUserAgent)
+	optional(fields, "trace_id", p.TraceId)
+	optional(fields, "span_id", p.SpanId)
+	optional(fields, "error.message", p.ErrorMessage)
+	optional(fields, "exception.stacktrace", p.Stack)
+	return requestLine(p), fields
+}
+
+// ApacheSchema renders the message in the Apache combined log format, with the same values as flat fields.
+var ApacheSchema Schema = func(p LogFormatterParams) (string, map[string]interface{}) {
+	fields := map[string]interface{}{
+		"method":     p.Method,
+		"status":     p.StatusCode,
+		"bytes":      p.BodySize,
+		"latency_ms": float64(p.Latency.Microseconds()) / 1000,
+		"path":       urlPath(p),
+		"protocol":   p.RequestProto,
+		"client_ip":  p.ClientIP,
+	}
+	optional(fields, "query", p.Query)
+	optional(fields, "route", p.Route)
+	optional(fields, "request_id", p.RequestId)
+	optional(fields, "referer", p.RequestReferer)
+	optional(fields, "request_body", p.RequestData)
+	optional(fields, "response_body", p.ResponseData)
+	size(fields, "request_bytes", p.RequestSize)
+	flag(fields, "request_truncated", p.RequestTruncated)
+	flag(fields, "response_truncated", p.ResponseTruncated)
+	optional(fields, "user_agent", p.RequestUserAgent)
+	optional(fields, "trace_id", p.TraceId)
+	optional(fields, "span_id", p.SpanId)
+	optional(fields, "error", p.ErrorMessage)
+	optional(fields, "stack", p.Stack)
+	return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %d %q %q",
+		p.ClientIP,
+		p.TimeStamp.Format("02/Jan/2006:15:04:05 -0700"),
+		p.Method, p.Path, p.RequestProto,
+		p.StatusCode, p.BodySize,
+		dash(p.RequestReferer), dash(p.RequestUserAgent),
+	), fields
+}
+
+func optional(fields map[string]interface{}, key, value string) {
+	if value != "" {
+		fields[key] = value
+	}
+}
+
+func size(fields map[string]interface{}, key string, value int64) {
+	if value > 0 {
+		fields[key] = value
+	}
+}
+
+func flag(fields map[string]interface{}, key string, value bool) {
+	if value {
+		fields[key] = true
+	}
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func requestLine(p LogFormatterParams) string {
+	return fmt.Sprintf("%s %s %d", p.Method, p.Path, p.StatusCode)
+}
+
+// urlPath returns the path without the query, Path includes it for the formatters.
+func urlPath(p LogFormatterParams) string {
+	return strings.TrimSuffix(p.Path, "?"+p.Query)
+}
+
+// correlationIds fills the request, trace and span IDs of param, from the request context
+// when they are in it, else from the headers set by the requestid and gintrace middlewares.
+func correlationIds(c *gin.Context, param *LogFormatterParams) {
+	if param.RequestId = requestid.FromContext(c.Request.Context()); param.RequestId == "" {
+		param.RequestId = firstHeader(c, "X-Request-Id")
+	}
+	if sc := oteltrace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+		param.TraceId, param.SpanId = sc.TraceID().String(), sc.SpanID().String()
+		return
+	}
+	param.TraceId = firstHeader(c, "trace-id")
+	param.SpanId = firstHeader(c, "span-id")
+}
+
+// firstHeader returns the header of the request, or of the response when the request has none.
+func firstHeader(c *gin.Context, key string) string {
+	if v := c.Request.Header.Get(key); v != "" {
+		return v
+	}
+	return c.Writer.Header().Get(key)
+}
+
+// logStructured writes one record with the fields of the schema. Bodies are only part of
+// the record when debug is enabled, like they are only logged at debug level otherwise.
+func (c *config) logStructured(level logrus.Level, param LogFormatterParams, suppressed int) {
+	// glog.ILogger.WithField returns a logrus entry
+	entry, ok := c.logger.(*logrus.Entry)
+	if !ok || !entry.Logger.IsLevelEnabled(logrus.DebugLevel) {
+		param.RequestData, param.ResponseData = "", ""
+	}
+	msg, fields := c.schema(param)
+	if suppressed > 0 {
+		fields["suppressed"] = suppressed
+	}
+	if ok {
+		entry.WithFields(fields).Log(level, msg)
+		return
+	}
+	// not a logrus entry, append the fields to the message in a stable order
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(msg)
+	for _, key := range keys {
+		fmt.Fprintf(&b, " %s=%v", key, fields[key])
+	}
+	logAt(c.logger, level, b.String())
+}
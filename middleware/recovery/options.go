@@ -0,0 +1,76 @@
+package recovery
+
+import (
+	"net/http"
+
+	"github.com/donetkit/contrib-gin/middleware/requestid"
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultRedactHeaders are the headers masked in the logged request when WithRedactHeaders is not given.
+var DefaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// Option for recovery
+type Option func(*config)
+
+// Renderer writes the response of a recovered panic.
+type Renderer func(c *gin.Context, err error)
+
+// PanicHandler is called with every recovered panic and its trimmed stack, e.g. to report it
+// to an error tracker. It is not called for broken connections.
+type PanicHandler func(c *gin.Context, err error, stack []byte)
+
+type config struct {
+	stack         bool
+	renderer      Renderer
+	panicHandler  PanicHandler
+	redactHeaders []string
+}
+
+// WithStack logs the stack of the panics.
+func WithStack(stack bool) Option {
+	return func(cfg *config) {
+		cfg.stack = stack
+	}
+}
+
+// WithRedactHeaders set the headers masked in the logged request, default DefaultRedactHeaders
+func WithRedactHeaders(names ...string) Option {
+	return func(cfg *config) {
+		cfg.redactHeaders = names
+	}
+}
+
+// WithRenderer set the renderer of the panic response, default responds with a bare 500.
+func WithRenderer(r Renderer) Option {
+	return func(cfg *config) {
+		cfg.renderer = r
+	}
+}
+
+// WithPanicHandler set the panic handler.
+func WithPanicHandler(h PanicHandler) Option {
+	return func(cfg *config) {
+		cfg.panicHandler = h
+	}
+}
+
+func defaultRenderer(c *gin.Context, err error) {
+	c.AbortWithStatus(http.StatusInternalServerError)
+}
+
+// ProblemRenderer responds with an RFC 7807 problem details body including the request id.
+// The panic message is not exposed to the client.
+func ProblemRenderer(c *gin.Context, err error) {
+	c.Header("Content-Type", "application/problem+json")
+	problem := gin.H{
+		"type":     "about:blank",
+		"title":    http.StatusText(http.StatusInternalServerError),
+		"status":   http.StatusInternalServerError,
+		"instance": c.Request.URL.Path,
+	}
+	if rid := requestid.Get(c); rid != "" {
+		problem["request_id"] = rid
+	}
+	c.AbortWithStatusJSON(http.StatusInternalServerError, problem)
+}
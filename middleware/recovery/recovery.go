@@ -1,50 +1,209 @@
 package recovery
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"github.com/donetkit/contrib-log/glog"
 	"net"
 	"net/http"
-	"net/http/httputil"
 	"os"
 	"runtime/debug"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/donetkit/contrib-gin/middleware/requestid"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
+const (
+	panicKey  = "go-contrib-recovery-panic"
+	loggedKey = "go-contrib-recovery-logged"
+)
+
+// Panic is a panic recovered while serving a request.
+type Panic struct {
+	// Err is the panic value, as an error.
+	Err error
+	// Stack is the stack of the panic, starting at the frame that panicked.
+	Stack []byte
+	// BrokenPipe is set when the panic means the client went away.
+	BrokenPipe bool
+	// Logged is set once the panic is logged, so it is logged once.
+	Logged bool
+}
+
+// Recovered returns the panic recovered while serving c, if any.
+func Recovered(c *gin.Context) (*Panic, bool) {
+	v, ok := c.Get(panicKey)
+	if !ok {
+		return nil, false
+	}
+	p, ok := v.(*Panic)
+	return p, ok
+}
+
+// DeferLogging marks the panics recovered while serving c as logged by the caller, an access
+// logger reading them with Recovered, so the recovery middleware doesn't log them again.
+// The returned func undoes it, the caller must call it when it is unwound by a panic,
+// as it can't log the panics it doesn't see.
+func DeferLogging(c *gin.Context) func() {
+	previous, _ := c.Get(loggedKey)
+	c.Set(loggedKey, true)
+	return func() {
+		c.Set(loggedKey, previous)
+	}
+}
+
+func loggingDeferred(c *gin.Context) bool {
+	return c.GetBool(loggedKey)
+}
+
 // New returns a gin.HandlerFunc (middleware)
 func New(logger glog.ILogger, stack ...bool) gin.HandlerFunc {
-	logs := logger.WithField("Gin-Recover", "Gin-Recover")
+	return NewWithOptions(logger, WithStack(len(stack) > 0 && stack[0]))
+}
+
+// NewWithOptions returns a gin.HandlerFunc (middleware) configured by opts.
+// The recovered panics are recorded on the context, see Recovered, and on the span of the request.
+// They are logged once, by the access logger when there is one.
+func NewWithOptions(logger glog.ILogger, opts ...Option) gin.HandlerFunc {
+	cfg := &config{renderer: defaultRenderer, redactHeaders: DefaultRedactHeaders}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	var logs glog.ILoggerEntry
+	if logger != nil {
+		logs = logger.WithField("Gin-Recover", "Gin-Recover")
+	}
 	return func(c *gin.Context) {
 		defer func() {
-			if err := recover(); err != nil {
-				var brokenPipe bool
-				if ne, ok := err.(*net.OpError); ok {
-					if se, ok := ne.Err.(*os.SyscallError); ok {
-						if strings.Contains(strings.ToLower(se.Error()), "broken pipe") || strings.Contains(strings.ToLower(se.Error()), "connection reset by peer") {
-							brokenPipe = true
-						}
-					}
+			if p := recover(); p != nil {
+				rp := &Panic{Err: panicError(p)}
+				rp.BrokenPipe = brokenPipe(rp.Err)
+				if !rp.BrokenPipe {
+					rp.Stack = trimStack(debug.Stack())
+				}
+				c.Set(panicKey, rp)
+				markSpan(c, rp)
+				if logs != nil && !loggingDeferred(c) {
+					logPanic(logs, c, rp, cfg)
+					rp.Logged = true
 				}
-				httpRequest, _ := httputil.DumpRequest(c.Request, false)
-				if brokenPipe {
-					logs.Error(fmt.Sprintf("path: %s error: %s request: %s", c.Request.URL.Path, err, string(httpRequest)))
+				if rp.BrokenPipe {
 					// If the connection is dead, we can't write a status to it.
-					c.Error(err.(error)) // nolint:
+					c.Error(rp.Err) // nolint:
 					c.Abort()
 					return
 				}
-				if len(stack) > 0 && stack[0] {
-					logs.Error("[Recovery from panic] %s error: %s request: %s stack: %s", time.Now().Format(time.RFC3339), err, string(httpRequest), string(debug.Stack()))
-
-				} else {
-					logs.Error("[Recovery from panic] %s error: %s request: %s", time.Now().Format(time.RFC3339), err, string(httpRequest))
+				if cfg.panicHandler != nil {
+					cfg.panicHandler(c, rp.Err, rp.Stack)
 				}
-				c.AbortWithStatus(http.StatusInternalServerError)
+				if c.Writer.Written() {
+					// the response has started, the status can't change
+					c.Abort()
+					return
+				}
+				cfg.renderer(c, rp.Err)
+				c.Abort()
 			}
 		}()
 		c.Next()
 	}
 }
+
+// markSpan records the panic on the span of the request as an error.
+func markSpan(c *gin.Context, p *Panic) {
+	span := oteltrace.SpanFromContext(c.Request.Context())
+	if !span.IsRecording() {
+		return
+	}
+	span.RecordError(p.Err, oteltrace.WithAttributes(attribute.String("exception.stacktrace", string(p.Stack))))
+	span.SetStatus(codes.Error, p.Err.Error())
+}
+
+// logPanic logs the panic with the request, when there is no access logger to do it.
+func logPanic(logs glog.ILoggerEntry, c *gin.Context, p *Panic, cfg *config) {
+	httpRequest := requestDump(c.Request, cfg.redactHeaders)
+	if p.BrokenPipe {
+		logs.Errorf("path: %s error: %s request: %s", c.Request.URL.Path, p.Err, httpRequest)
+		return
+	}
+	var traceId string
+	if sc := oteltrace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+		traceId = sc.TraceID().String()
+	}
+	msg := fmt.Sprintf("[Recovery from panic] %s error: %s route: %s request_id: %s trace_id: %s request: %s",
+		time.Now().Format(time.RFC3339), p.Err, c.FullPath(), requestid.Get(c), traceId, httpRequest)
+	if cfg.stack {
+		msg += " stack: " + string(p.Stack)
+	}
+	logs.Error(msg)
+}
+
+// requestDump returns the request line and the headers of req, with the values of the denied
+// headers masked. The query is left out like the body, it may hold credentials too.
+func requestDump(req *http.Request, denied []string) string {
+	header := req.Header.Clone()
+	for _, key := range denied {
+		if values, ok := header[http.CanonicalHeaderKey(key)]; ok {
+			for i := range values {
+				values[i] = "[REDACTED]"
+			}
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %s\r\nHost: %s\r\n", req.Method, req.URL.EscapedPath(), req.Proto, req.Host)
+	header.Write(&b)
+	return b.String()
+}
+
+// panicError returns the panic value p as an error.
+func panicError(p interface{}) error {
+	if err, ok := p.(error); ok {
+		return err
+	}
+	return fmt.Errorf("%v", p)
+}
+
+// brokenPipe reports whether err means the client went away, so no response can be written.
+func brokenPipe(err error) bool {
+	if errors.Is(err, http.ErrAbortHandler) || errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var ne *net.OpError
+	if !errors.As(err, &ne) {
+		return false
+	}
+	var se *os.SyscallError
+	if errors.As(ne, &se) {
+		msg := strings.ToLower(se.Error())
+		return strings.Contains(msg, "broken pipe") || strings.Contains(msg, "connection reset by peer")
+	}
+	return false
+}
+
+// trimStack removes the frames of the recovery from a stack of debug.Stack, so it starts at
+// the frame that panicked.
+func trimStack(stack []byte) []byte {
+	// the deepest panic is the one raised first, deferred functions may panic again
+	i := bytes.LastIndex(stack, []byte("\npanic("))
+	if i < 0 {
+		return stack
+	}
+	// skip the panic function and its file line
+	rest := stack[i+1:]
+	for n := 0; n < 2; n++ {
+		j := bytes.IndexByte(rest, '\n')
+		if j < 0 {
+			return stack
+		}
+		rest = rest[j+1:]
+	}
+	header := stack[:bytes.IndexByte(stack, '\n')+1]
+	return append(append([]byte{}, header...), rest...)
+}
@@ -0,0 +1,124 @@
+package recovery
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/donetkit/contrib-gin/middleware/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func panicking(c *gin.Context) {
+	panic("oops")
+}
+
+func TestNew(t *testing.T) {
+	r := gin.New()
+	r.Use(New(nil, true))
+	r.GET("/", panicking)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Empty(t, w.Body.String())
+}
+
+func TestProblemRendererAndPanicHandler(t *testing.T) {
+	var reported error
+	var stack []byte
+	r := gin.New()
+	r.Use(requestid.New(), NewWithOptions(nil, WithRenderer(ProblemRenderer), WithPanicHandler(func(c *gin.Context, err error, s []byte) {
+		reported, stack = err, s
+	})))
+	r.GET("/", panicking)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-Id", "rid-1")
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"type":"about:blank","title":"Internal Server Error","status":500,"instance":"/","request_id":"rid-1"}`, w.Body.String())
+	assert.EqualError(t, reported, "oops")
+	lines := strings.Split(string(stack), "\n")
+	if assert.Greater(t, len(lines), 2) {
+		assert.True(t, strings.HasPrefix(lines[0], "goroutine "))
+		assert.Contains(t, lines[1], "recovery.panicking", "the stack starts at the panicking frame")
+	}
+}
+
+func TestBrokenPipe(t *testing.T) {
+	opErr := &net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EPIPE)}
+	for _, err := range []error{
+		http.ErrAbortHandler,
+		opErr,
+		fmt.Errorf("copy response: %w", opErr),
+		fmt.Errorf("write: %w", syscall.ECONNRESET),
+	} {
+		assert.True(t, brokenPipe(err), err)
+	}
+	assert.False(t, brokenPipe(errors.New("oops")))
+
+	called := false
+	r := gin.New()
+	r.Use(NewWithOptions(nil, WithPanicHandler(func(*gin.Context, error, []byte) {
+		called = true
+	})))
+	r.GET("/", func(c *gin.Context) {
+		panic(http.ErrAbortHandler)
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.False(t, called, "broken connections are not reported")
+}
+
+func TestSpanMarked(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		ctx, span := tracer.Start(c.Request.Context(), "request")
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}, New(nil))
+	r.GET("/", panicking)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, codes.Error, spans[0].Status().Code)
+		assert.Equal(t, "oops", spans[0].Status().Description)
+		if assert.Len(t, spans[0].Events(), 1) {
+			assert.Equal(t, "exception", spans[0].Events()[0].Name)
+		}
+	}
+}
+
+func TestRequestDumpRedacted(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/login?password=hunter2", strings.NewReader("password=hunter2"))
+	req.Header.Set("Authorization", "Bearer secret-token")
+	req.Header.Set("Cookie", "session=secret-cookie")
+	req.Header.Set("User-Agent", "curl/8.0")
+
+	dump := requestDump(req, DefaultRedactHeaders)
+	assert.True(t, strings.HasPrefix(dump, "POST /login HTTP/1.1\r\nHost: example.com\r\n"), dump)
+	assert.Contains(t, dump, "Authorization: [REDACTED]\r\n")
+	assert.Contains(t, dump, "Cookie: [REDACTED]\r\n")
+	assert.Contains(t, dump, "User-Agent: curl/8.0\r\n")
+	for _, secret := range []string{"secret-token", "secret-cookie", "hunter2"} {
+		assert.NotContains(t, dump, secret)
+	}
+}
@@ -0,0 +1,109 @@
+package requestid
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Format is the format of the generated ids.
+type Format int
+
+const (
+	// FormatUUIDv4 is a random UUID.
+	FormatUUIDv4 Format = iota + 1
+	// FormatUUIDv7 is a time-ordered UUID.
+	FormatUUIDv7
+	// FormatULID is a lexicographically sortable id in Crockford's base32.
+	FormatULID
+	// FormatTraceParent is the trace ID of the W3C traceparent header of the request,
+	// or a random trace ID when the request has none.
+	FormatTraceParent
+)
+
+var timeNow = time.Now
+
+// crockford is the alphabet of the ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// generate returns a new id in format f, traceparent is the header of the request.
+func (f Format) generate(traceparent string) string {
+	switch f {
+	case FormatUUIDv7:
+		return newUUIDv7()
+	case FormatULID:
+		return newULID()
+	case FormatTraceParent:
+		if id, ok := traceID(traceparent); ok {
+			return id
+		}
+		b := random(16)
+		return hex.EncodeToString(b)
+	}
+	return newUUIDv4()
+}
+
+func random(n int) []byte {
+	b := make([]byte, n)
+	// crypto/rand does not fail on supported platforms
+	rand.Read(b)
+	return b
+}
+
+func newUUIDv4() string {
+	b := random(16)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return formatUUID(b)
+}
+
+// newUUIDv7 returns a UUID starting with the unix time in milliseconds, see RFC 9562.
+func newUUIDv7() string {
+	b := random(16)
+	putMillis(b)
+	b[6] = b[6]&0x0f | 0x70
+	b[8] = b[8]&0x3f | 0x80
+	return formatUUID(b)
+}
+
+// putMillis writes the unix time in milliseconds to the first 48 bits of b.
+func putMillis(b []byte) {
+	ms := uint64(timeNow().UnixMilli())
+	binary.BigEndian.PutUint16(b[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(b[2:6], uint32(ms))
+}
+
+func formatUUID(b []byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// newULID returns a 48 bit timestamp in milliseconds followed by 80 random bits, in 26 characters.
+func newULID() string {
+	b := random(16)
+	putMillis(b)
+	hi, lo := binary.BigEndian.Uint64(b[0:8]), binary.BigEndian.Uint64(b[8:16])
+	var s [26]byte
+	// 128 bits in 26 characters of 5 bits, the first one holds 3 bits
+	for i := 25; i >= 0; i-- {
+		s[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(s[:])
+}
+
+// traceID returns the trace ID of a traceparent header, "00-<trace-id>-<parent-id>-<flags>".
+func traceID(traceparent string) (string, bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", false
+	}
+	id := strings.ToLower(parts[1])
+	if _, err := hex.DecodeString(id); err != nil || id == strings.Repeat("0", 32) {
+		return "", false
+	}
+	return id, true
+}
@@ -1,5 +1,7 @@
 package requestid
 
+import "regexp"
+
 // Option for queue system
 type Option func(*config)
 
@@ -12,9 +14,41 @@ func WithGenerator(g Generator) Option {
 	}
 }
 
+// WithFormat set the format of the generated ids, it replaces the generator.
+func WithFormat(f Format) Option {
+	return func(cfg *config) {
+		cfg.format = f
+	}
+}
+
 // WithCustomHeaderStrKey set custom header key for request id
 func WithCustomHeaderStrKey(s string) Option {
 	return func(cfg *config) {
 		cfg.headerKey = s
 	}
 }
+
+// WithMaxLength set the max length of the incoming ids, longer ids are replaced by a generated one.
+// Default 128, 0 disables the check.
+func WithMaxLength(n int) Option {
+	return func(cfg *config) {
+		cfg.maxLength = n
+	}
+}
+
+// WithCharset set the pattern the incoming ids must match as a whole, e.g. `[A-Za-z0-9-]+`, it is
+// anchored at both ends. Any printable ASCII character except the space is allowed by default,
+// others never are.
+func WithCharset(charset *regexp.Regexp) Option {
+	return func(cfg *config) {
+		cfg.charset = anchor(charset)
+	}
+}
+
+// WithTrustedProxies only trusts the incoming ids of requests from the given CIDRs or IPs,
+// the ids of other clients are replaced by a generated one. By default, every client is trusted.
+func WithTrustedProxies(cidrs ...string) Option {
+	return func(cfg *config) {
+		cfg.trustedProxies = append(cfg.trustedProxies, cidrs...)
+	}
+}
@@ -1,49 +1,225 @@
 package requestid
 
 import (
+	"context"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
 	"github.com/donetkit/contrib/utils/uuid"
 	"github.com/gin-gonic/gin"
 )
 
-var headerXRequestID string
-
 var headerRequestIdKey = "X-Request-Id"
 
+// DefaultMaxLength is the default max length of the incoming ids.
+const DefaultMaxLength = 128
+
+type ctxMarker struct{}
+
+// ctxMarkerKey is the Context value marker of the request id.
+var ctxMarkerKey = &ctxMarker{}
+
+// ctxValue is the request id and the header it is read from and written to.
+type ctxValue struct {
+	id        string
+	headerKey string
+}
+
 // Config defines the config for RequestID middleware
 type config struct {
 	// Generator defines a function to generate an ID.
 	// Optional. Default: func() string {
 	//   return uuid.New().String()
 	// }
-	generator Generator
-	headerKey string
+	generator      Generator
+	format         Format
+	headerKey      string
+	maxLength      int
+	charset        *regexp.Regexp
+	trustedProxies []string
+	trusted        []*net.IPNet
 }
 
-// New initializes the RequestID middleware.
-func New(opts ...Option) gin.HandlerFunc {
+func newConfig(opts ...Option) *config {
 	cfg := &config{
 		generator: func() string {
 			return uuid.NewUUID()
 		},
 		headerKey: headerRequestIdKey,
+		maxLength: DefaultMaxLength,
 	}
 	for _, opt := range opts {
 		opt(cfg)
 	}
-	headerXRequestID = cfg.headerKey
+	for _, cidr := range cfg.trustedProxies {
+		cfg.trusted = append(cfg.trusted, parseCIDR(cidr))
+	}
+	return cfg
+}
+
+// parseCIDR parses a CIDR or a single IP, it panics when it is invalid like a misconfigured middleware does.
+func parseCIDR(cidr string) *net.IPNet {
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			panic("requestid: invalid trusted proxy " + cidr)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic("requestid: invalid trusted proxy " + cidr)
+	}
+	return network
+}
+
+// New initializes the RequestID middleware.
+func New(opts ...Option) gin.HandlerFunc {
+	cfg := newConfig(opts...)
 	return func(c *gin.Context) {
 		// Get id from request
 		rid := c.GetHeader(cfg.headerKey)
-		if rid == "" {
-			rid = cfg.generator()
+		if rid == "" || !cfg.trust(c.Request) || !cfg.valid(rid) {
+			rid = cfg.generate(c.Request)
 		}
 		// Set the id to ensure that the requestid is in the response
 		c.Header(cfg.headerKey, rid)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), ctxMarkerKey, ctxValue{id: rid, headerKey: cfg.headerKey}))
 		c.Next()
 	}
 }
 
+// trust reports whether the id of the request may be used.
+func (cfg *config) trust(req *http.Request) bool {
+	if len(cfg.trusted) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range cfg.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// valid reports whether rid is short enough and made of allowed characters.
+func (cfg *config) valid(rid string) bool {
+	return Valid(rid, cfg.maxLength, cfg.charset)
+}
+
+// Valid reports whether the incoming id rid is at most maxLength long, 0 is no limit, is made of
+// printable ASCII characters except the space, and is matched as a whole by charset, if given.
+func Valid(rid string, maxLength int, charset *regexp.Regexp) bool {
+	if maxLength > 0 && len(rid) > maxLength {
+		return false
+	}
+	for i := 0; i < len(rid); i++ {
+		if rid[i] <= ' ' || rid[i] > '~' {
+			return false
+		}
+	}
+	if charset != nil {
+		loc := charset.FindStringIndex(rid)
+		return loc != nil && loc[0] == 0 && loc[1] == len(rid)
+	}
+	return true
+}
+
+// anchor returns charset matching whole ids only.
+func anchor(charset *regexp.Regexp) *regexp.Regexp {
+	if charset == nil {
+		return nil
+	}
+	return regexp.MustCompile(`^(?:` + charset.String() + `)$`)
+}
+
+func (cfg *config) generate(req *http.Request) string {
+	if cfg.format != 0 {
+		return cfg.format.generate(req.Header.Get("traceparent"))
+	}
+	return cfg.generator()
+}
+
 // Get returns the request identifier
 func Get(c *gin.Context) string {
-	return c.Writer.Header().Get(headerXRequestID)
+	if v, ok := c.Request.Context().Value(ctxMarkerKey).(ctxValue); ok {
+		return v.id
+	}
+	return c.Writer.Header().Get(headerRequestIdKey)
+}
+
+// NewContext returns a copy of ctx carrying the request id.
+func NewContext(ctx context.Context, rid string) context.Context {
+	return context.WithValue(ctx, ctxMarkerKey, ctxValue{id: rid, headerKey: HeaderFromContext(ctx)})
+}
+
+// FromContext returns the request id carried by ctx, or "" when there is none.
+func FromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	v, _ := ctx.Value(ctxMarkerKey).(ctxValue)
+	return v.id
+}
+
+// HeaderFromContext returns the header of the request id of the middleware which served the
+// request of ctx, or the default X-Request-Id.
+func HeaderFromContext(ctx context.Context) string {
+	if ctx != nil {
+		if v, ok := ctx.Value(ctxMarkerKey).(ctxValue); ok && v.headerKey != "" {
+			return v.headerKey
+		}
+	}
+	return headerRequestIdKey
+}
+
+// transport forwards the request id of the request context.
+type transport struct {
+	base      http.RoundTripper
+	headerKey string
+}
+
+// NewTransport returns an http.RoundTripper setting the request id header of the outgoing
+// requests from their context, so the id follows the calls made while serving a request.
+// base defaults to http.DefaultTransport. The header is the one of the context, unless one
+// is set with WithCustomHeaderStrKey.
+func NewTransport(base http.RoundTripper, opts ...Option) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	cfg := &config{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return &transport{base: base, headerKey: cfg.headerKey}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rid := FromContext(req.Context())
+	headerKey := t.headerKey
+	if headerKey == "" {
+		headerKey = HeaderFromContext(req.Context())
+	}
+	if rid == "" || req.Header.Get(headerKey) != "" {
+		return t.base.RoundTrip(req)
+	}
+	// a RoundTripper must not modify the request
+	req = req.Clone(req.Context())
+	req.Header.Set(headerKey, rid)
+	return t.base.RoundTrip(req)
 }
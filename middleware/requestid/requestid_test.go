@@ -4,7 +4,10 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -26,7 +29,7 @@ func Test_RequestID_CreateNew(t *testing.T) {
 	r.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
-	assert.NotEmpty(t, w.Header().Get(headerXRequestID))
+	assert.NotEmpty(t, w.Header().Get(headerRequestIdKey))
 }
 
 func Test_RequestID_PassThru(t *testing.T) {
@@ -36,11 +39,11 @@ func Test_RequestID_PassThru(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
-	req.Header.Set(headerXRequestID, testXRequestID)
+	req.Header.Set(headerRequestIdKey, testXRequestID)
 	r.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
-	assert.Equal(t, testXRequestID, w.Header().Get(headerXRequestID))
+	assert.Equal(t, testXRequestID, w.Header().Get(headerRequestIdKey))
 }
 
 func TestRequestIDWithCustomID(t *testing.T) {
@@ -59,7 +62,7 @@ func TestRequestIDWithCustomID(t *testing.T) {
 	r.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
-	assert.Equal(t, testXRequestID, w.Header().Get(headerXRequestID))
+	assert.Equal(t, testXRequestID, w.Header().Get(headerRequestIdKey))
 }
 
 func TestRequestIDWithCustomHeaderKey(t *testing.T) {
@@ -79,3 +82,148 @@ func TestRequestIDWithCustomHeaderKey(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Equal(t, testXRequestID, w.Header().Get("customKey"))
 }
+
+func TestRequestIDContext(t *testing.T) {
+	var rid, got string
+	r := gin.New()
+	r.Use(New())
+	r.GET("/", func(c *gin.Context) {
+		rid = FromContext(c.Request.Context())
+		got = Get(c)
+		c.String(http.StatusOK, "")
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+	req.Header.Set(headerRequestIdKey, testXRequestID)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, testXRequestID, rid)
+	assert.Equal(t, testXRequestID, got)
+	assert.Empty(t, FromContext(context.Background()))
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestTransport(t *testing.T) {
+	var header string
+	client := &http.Client{Transport: NewTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		header = req.Header.Get("customKey")
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+	}), WithCustomHeaderStrKey("customKey"))}
+
+	req, _ := http.NewRequestWithContext(NewContext(context.Background(), testXRequestID), "GET", "http://example.com/", nil)
+	if _, err := client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, testXRequestID, header)
+	assert.Empty(t, req.Header.Get("customKey"), "the request of the caller is not modified")
+
+	req, _ = http.NewRequestWithContext(context.Background(), "GET", "http://example.com/", nil)
+	client.Do(req)
+	assert.Empty(t, header)
+}
+
+func serve(r http.Handler, remoteAddr string, header http.Header) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+	req.RemoteAddr = remoteAddr
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRequestIDValidation(t *testing.T) {
+	r := gin.New()
+	r.Use(New(WithMaxLength(20), WithCharset(regexp.MustCompile(`^[a-z-]+$`))))
+	r.GET("/", emptySuccessResponse)
+
+	w := serve(r, "192.0.2.1:1234", http.Header{headerRequestIdKey: {testXRequestID}})
+	assert.Equal(t, testXRequestID, w.Header().Get(headerRequestIdKey))
+	for _, rid := range []string{strings.Repeat("a", 21), "Test", "a\nb"} {
+		w = serve(r, "192.0.2.1:1234", http.Header{headerRequestIdKey: {rid}})
+		assert.NotEqual(t, rid, w.Header().Get(headerRequestIdKey))
+		assert.NotEmpty(t, w.Header().Get(headerRequestIdKey))
+	}
+
+	r = gin.New()
+	r.Use(New())
+	r.GET("/", emptySuccessResponse)
+	w = serve(r, "192.0.2.1:1234", http.Header{headerRequestIdKey: {"forged\r\nlevel=error"}})
+	assert.NotContains(t, w.Header().Get(headerRequestIdKey), "forged")
+
+	// an unanchored charset must match the whole id
+	r = gin.New()
+	r.Use(New(WithCharset(regexp.MustCompile(`[a-z0-9-]+`))))
+	r.GET("/", emptySuccessResponse)
+	for _, rid := range []string{"abc\r\nSet-Cookie: a=b", "abc def", "<script>"} {
+		w = serve(r, "192.0.2.1:1234", http.Header{headerRequestIdKey: {rid}})
+		assert.NotEqual(t, rid, w.Header().Get(headerRequestIdKey))
+	}
+	assert.False(t, Valid("abc\r\nSet-Cookie: a=b", 0, regexp.MustCompile(`[a-z]+`)))
+	assert.False(t, Valid("abc!", 0, regexp.MustCompile(`[a-z]+`)))
+	assert.True(t, Valid("abc", 0, regexp.MustCompile(`[a-z]+`)))
+}
+
+func TestRequestIDTrustedProxies(t *testing.T) {
+	r := gin.New()
+	r.Use(New(WithTrustedProxies("10.0.0.0/8", "192.0.2.7")))
+	r.GET("/", emptySuccessResponse)
+
+	header := http.Header{headerRequestIdKey: {testXRequestID}}
+	assert.Equal(t, testXRequestID, serve(r, "10.1.2.3:1234", header).Header().Get(headerRequestIdKey))
+	assert.Equal(t, testXRequestID, serve(r, "192.0.2.7:1234", header).Header().Get(headerRequestIdKey))
+	assert.NotEqual(t, testXRequestID, serve(r, "192.0.2.8:1234", header).Header().Get(headerRequestIdKey))
+
+	assert.Panics(t, func() { New(WithTrustedProxies("10.0.0.0/33")) })
+}
+
+func TestRequestIDFormats(t *testing.T) {
+	patterns := map[Format]string{
+		FormatUUIDv4:      `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`,
+		FormatUUIDv7:      `^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`,
+		FormatULID:        `^[0-7][0-9A-HJKMNP-TV-Z]{25}$`,
+		FormatTraceParent: `^[0-9a-f]{32}$`,
+	}
+	for format, pattern := range patterns {
+		r := gin.New()
+		r.Use(New(WithFormat(format)))
+		r.GET("/", emptySuccessResponse)
+		assert.Regexp(t, pattern, serve(r, "192.0.2.1:1234", nil).Header().Get(headerRequestIdKey))
+	}
+
+	r := gin.New()
+	r.Use(New(WithFormat(FormatTraceParent)))
+	r.GET("/", emptySuccessResponse)
+	w := serve(r, "192.0.2.1:1234", http.Header{"Traceparent": {"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"}})
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", w.Header().Get(headerRequestIdKey))
+}
+
+func TestTimeOrderedFormats(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	timeNow = func() time.Time { return time.UnixMilli(1700000000000) }
+	first, firstULID := newUUIDv7(), newULID()
+	timeNow = func() time.Time { return time.UnixMilli(1700000000001) }
+	assert.Less(t, first, newUUIDv7())
+	assert.Less(t, firstULID, newULID())
+	assert.Equal(t, "018bcfe56800", strings.ReplaceAll(first, "-", "")[:12])
+}
+
+func TestHeaderFromContext(t *testing.T) {
+	var header string
+	r := gin.New()
+	r.Use(New(WithCustomHeaderStrKey("X-Correlation-Id")))
+	r.GET("/", func(c *gin.Context) {
+		header = HeaderFromContext(c.Request.Context())
+		c.String(http.StatusOK, "")
+	})
+	serve(r, "192.0.2.1:1234", nil)
+	assert.Equal(t, "X-Correlation-Id", header)
+	assert.Equal(t, headerRequestIdKey, HeaderFromContext(context.Background()))
+}
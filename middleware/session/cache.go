@@ -1,12 +1,25 @@
 package session
 
 import (
+	"context"
+
 	"github.com/donetkit/contrib/utils/cache"
-	"github.com/gorilla/sessions"
 )
 
 type ICacheStore interface {
 	SessionsStore
+	// SetSlidingExpiration and SetAbsoluteMaxAge set the idle and absolute timeouts,
+	// see CacheStore.SetSlidingExpiration.
+	SetSlidingExpiration(throttle int)
+	SetAbsoluteMaxAge(v int)
+	// SetSerializer sets the serializer, see NewEncryptedSerializer and NewCompressedSerializer.
+	SetSerializer(ss SessionSerializer)
+	// SetUserKey enables the per-user session index, see CacheStore.SetUserKey.
+	SetUserKey(key string)
+	ListSessions(ctx context.Context, userID string) []SessionInfo
+	CountSessions(ctx context.Context, userID string) int
+	RevokeSession(ctx context.Context, id string) error
+	RevokeAll(ctx context.Context, userID string) int
 }
 
 // NewStore size: maximum number of idle connections.
@@ -24,19 +37,19 @@ type ICacheStore interface {
 // if set, must be either 16, 24, or 32 bytes to select AES-128, AES-192, or AES-256 modes.
 func NewStore(cache cache.ICache, keyPairs ...[]byte) (ICacheStore, error) {
 	store := NewCacheStore(cache, keyPairs...)
-	return &cacheStore{store}, nil
+	return &cacheStore{CacheStore: store}, nil
 }
 
 type cacheStore struct {
 	*CacheStore
+	partitionedOption bool
 }
 
 func (c *cacheStore) Options(options Options) {
-	c.CacheStore.Options = &sessions.Options{
-		Path:     options.Path,
-		Domain:   options.Domain,
-		MaxAge:   options.MaxAge,
-		Secure:   options.Secure,
-		HttpOnly: options.HttpOnly,
-	}
+	c.CacheStore.Options = options.sessionsOptions()
+	c.partitionedOption = options.Partitioned
+}
+
+func (c *cacheStore) partitioned() bool {
+	return c.partitionedOption
 }
@@ -1,13 +1,140 @@
 package session
 
 import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/donetkit/contrib/db/redis"
+	"github.com/donetkit/contrib/utils/cache"
+	"github.com/gorilla/sessions"
 )
 
 const redisTestServer = "localhost:6379"
 
-var newRedisStore = func(_ *testing.T) SessionsStore {
-	store, err := NewStore(nil, []byte("secret"))
+var newRedisStore = func(t *testing.T) SessionsStore {
+	conn, err := net.DialTimeout("tcp", redisTestServer, time.Second)
+	if err != nil {
+		t.Skipf("redis server not available at %s: %v", redisTestServer, err)
+	}
+	conn.Close()
+	host, port, _ := net.SplitHostPort(redisTestServer)
+	portNum, _ := strconv.Atoi(port)
+	store, err := NewStore(redis.New(redis.WithAddr(host), redis.WithPort(portNum)), []byte("secret"))
+	if err != nil {
+		panic(err)
+	}
+	return store
+}
+
+// memoryCache is an in-memory cache.ICache covering the commands used by CacheStore.
+type memoryCache struct {
+	cache.ICache
+	mu     sync.Mutex
+	items  map[string]interface{}
+	ttls   map[string]time.Duration
+	hashes map[string]map[string]string
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{
+		items:  make(map[string]interface{}),
+		ttls:   make(map[string]time.Duration),
+		hashes: make(map[string]map[string]string),
+	}
+}
+
+func (m *memoryCache) WithDB(int) cache.ICache { return m }
+
+func (m *memoryCache) WithContext(context.Context) cache.ICache { return m }
+
+func (m *memoryCache) Get(key string) interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.items[key]
+}
+
+func (m *memoryCache) Set(key string, val interface{}, expire time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.items[key] = val
+	m.ttls[key] = expire
+	return nil
+}
+
+func (m *memoryCache) Delete(keys ...string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for _, key := range keys {
+		if _, ok := m.items[key]; ok {
+			delete(m.items, key)
+			n++
+		}
+		if _, ok := m.hashes[key]; ok {
+			delete(m.hashes, key)
+			n++
+		}
+	}
+	return n
+}
+
+func (m *memoryCache) HashSet(key string, values ...interface{}) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.hashes[key]
+	if !ok {
+		h = make(map[string]string)
+		m.hashes[key] = h
+	}
+	for i := 0; i+1 < len(values); i += 2 {
+		h[fmt.Sprint(values[i])] = fmt.Sprint(values[i+1])
+	}
+	return int64(len(values) / 2)
+}
+
+func (m *memoryCache) HashAll(key string) map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	all := make(map[string]string, len(m.hashes[key]))
+	for field, val := range m.hashes[key] {
+		all[field] = val
+	}
+	return all
+}
+
+func (m *memoryCache) HashKeys(key string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	for field := range m.hashes[key] {
+		keys = append(keys, field)
+	}
+	return keys
+}
+
+func (m *memoryCache) HashDel(key string, fields ...string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for _, field := range fields {
+		if _, ok := m.hashes[key][field]; ok {
+			delete(m.hashes[key], field)
+			n++
+		}
+	}
+	return n
+}
+
+var newMemoryStore = func(_ *testing.T) SessionsStore {
+	store, err := NewStore(newMemoryCache(), []byte("secret"))
 	if err != nil {
 		panic(err)
 	}
@@ -33,3 +160,186 @@ func TestRedis_SessionClear(t *testing.T) {
 func TestRedis_SessionOptions(t *testing.T) {
 	sessionOptions(t, newRedisStore)
 }
+
+func TestCache_SessionGetSet(t *testing.T) {
+	sessionGetSet(t, newMemoryStore)
+}
+
+func TestCache_SessionMany(t *testing.T) {
+	sessionMany(t, newMemoryStore)
+}
+
+func TestCache_SessionRegenerate(t *testing.T) {
+	sessionRegenerate(t, newMemoryStore)
+}
+
+func TestCacheStore_Regenerate(t *testing.T) {
+	mc := newMemoryCache()
+	store := NewCacheStore(mc, []byte("secret"))
+	req, _ := http.NewRequest("GET", "/", nil)
+
+	session := sessions.NewSession(store, sessionName)
+	session.Options = &sessions.Options{Path: "/", MaxAge: 60}
+	session.Values["key"] = ok
+	if err := store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatal(err)
+	}
+	id := session.ID
+
+	if err := store.Regenerate(req, session); err != nil {
+		t.Fatal(err)
+	}
+	if session.ID == "" || session.ID == id {
+		t.Error("Regenerate must assign a new session ID")
+	}
+	if mc.Get("session_"+id) != nil {
+		t.Error("Regenerate must remove the session stored under the old ID")
+	}
+	if session.Values["key"] != ok {
+		t.Error("Regenerate must keep the session values")
+	}
+
+	if err := store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatal(err)
+	}
+	if mc.Get("session_"+session.ID) == nil {
+		t.Error("Save must store the session under the new ID")
+	}
+}
+
+func TestCacheStore_UserSessions(t *testing.T) {
+	mc := newMemoryCache()
+	store := NewCacheStore(mc, []byte("secret"))
+	store.SetUserKey("user_id")
+	req, _ := http.NewRequest("GET", "/", nil)
+	ctx := req.Context()
+
+	login := func(user interface{}) *sessions.Session {
+		session := sessions.NewSession(store, sessionName)
+		session.Options = &sessions.Options{Path: "/", MaxAge: 60}
+		session.Values["user_id"] = user
+		if err := store.Save(req, httptest.NewRecorder(), session); err != nil {
+			t.Fatal(err)
+		}
+		return session
+	}
+	laptop, phone, tablet := login("alice"), login("alice"), login("alice")
+	bob := login(1000000)
+
+	if n := store.CountSessions(ctx, "alice"); n != 3 {
+		t.Errorf("CountSessions = %d, want 3", n)
+	}
+	if list := store.ListSessions(ctx, "1000000"); len(list) != 1 || list[0].ID != bob.ID {
+		t.Errorf("ListSessions = %v, want the session of bob", list)
+	}
+
+	if err := store.RevokeSession(ctx, laptop.ID); err != nil {
+		t.Fatal(err)
+	}
+	if mc.Get("session_"+laptop.ID) != nil {
+		t.Error("RevokeSession must remove the session")
+	}
+	// an expired session is dropped from the index
+	mc.Delete("session_" + tablet.ID)
+	if list := store.ListSessions(ctx, "alice"); len(list) != 1 || list[0].ID != phone.ID {
+		t.Errorf("ListSessions = %v, want the phone session only", list)
+	}
+	if ids := mc.HashKeys("session_user_alice"); len(ids) != 1 {
+		t.Errorf("index = %v, want stale sessions removed", ids)
+	}
+
+	if n := store.RevokeAll(ctx, "alice"); n != 1 {
+		t.Errorf("RevokeAll = %d, want 1", n)
+	}
+	if mc.Get("session_"+phone.ID) != nil || store.CountSessions(ctx, "alice") != 0 {
+		t.Error("RevokeAll must remove every session of the user")
+	}
+	if store.CountSessions(ctx, "1000000") != 1 {
+		t.Error("RevokeAll must keep the sessions of other users")
+	}
+}
+
+func TestCacheStore_SlidingExpiration(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	start := time.Unix(1700000000, 0)
+	at := func(d time.Duration) { timeNow = func() time.Time { return start.Add(d) } }
+
+	mc := newMemoryCache()
+	store := NewCacheStore(mc, []byte("secret"))
+	store.Options.MaxAge = 15 * 60
+	store.SetSlidingExpiration(60)
+	store.SetAbsoluteMaxAge(8 * 3600)
+
+	at(0)
+	req, _ := http.NewRequest("GET", "/", nil)
+	res := httptest.NewRecorder()
+	session, _ := store.New(req, sessionName)
+	session.Values["key"] = ok
+	if err := store.Save(req, res, session); err != nil {
+		t.Fatal(err)
+	}
+	key, metaKey := "session_"+session.ID, "session_meta_"+session.ID
+	if len(session.Values) != 1 {
+		t.Errorf("Values = %v, want the user values only", session.Values)
+	}
+	if cookie := res.Header().Get("Set-Cookie"); !strings.Contains(cookie, "Max-Age=28800") {
+		t.Error("The cookie must last until the absolute lifetime:", cookie)
+	}
+	if mc.ttls[key] != 8*time.Hour {
+		t.Errorf("TTL = %s, want the absolute lifetime", mc.ttls[key])
+	}
+	if mc.ttls[metaKey] != 15*time.Minute {
+		t.Errorf("metadata TTL = %s, want the idle timeout", mc.ttls[metaKey])
+	}
+
+	read := func(d time.Duration) *sessions.Session {
+		at(d)
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+		session, err := store.New(req, sessionName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return session
+	}
+
+	stored, meta := mc.Get(key), mc.Get(metaKey)
+	read(30 * time.Second)
+	if mc.Get(metaKey) != meta {
+		t.Error("Reading within the throttle must not refresh the TTL")
+	}
+	if session := read(10 * time.Minute); len(session.Values) != 1 {
+		t.Errorf("Values = %v, want the user values only", session.Values)
+	}
+	if mc.Get(metaKey) == meta || mc.ttls[metaKey] != 15*time.Minute {
+		t.Error("Reading the session must refresh the TTL")
+	}
+	if mc.Get(key) != stored || mc.ttls[key] != 8*time.Hour {
+		t.Error("Reading the session must not rewrite its values")
+	}
+
+	read(8*time.Hour - 100*time.Second)
+	if mc.ttls[metaKey] != 100*time.Second {
+		t.Errorf("TTL = %s, want it capped by the absolute lifetime", mc.ttls[metaKey])
+	}
+
+	if session := read(8 * time.Hour); !session.IsNew || session.Values["key"] != nil {
+		t.Error("The session must expire at the absolute lifetime")
+	}
+	if mc.Get(key) != nil || mc.Get(metaKey) != nil {
+		t.Error("An expired session must be removed")
+	}
+
+	// the metadata expires with the idle timeout
+	at(0)
+	res = httptest.NewRecorder()
+	session, _ = store.New(req, sessionName)
+	session.Values["key"] = ok
+	if err := store.Save(req, res, session); err != nil {
+		t.Fatal(err)
+	}
+	mc.Delete("session_meta_" + session.ID)
+	if session := read(20 * time.Minute); !session.IsNew {
+		t.Error("The session must expire after the idle timeout")
+	}
+}
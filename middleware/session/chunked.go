@@ -0,0 +1,135 @@
+package session
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// cookieChunkSize keeps every chunk, with its name and attributes, below the 4KB browser limit.
+const cookieChunkSize = 3800
+
+// NewChunkedCookieStore returns a cookie store for sessions larger than a single cookie:
+// the encoded session is split across name_0..name_N cookies and reassembled on read.
+// The keys are used as in NewCookieStore.
+func NewChunkedCookieStore(keyPairs ...[]byte) CookieStore {
+	codecs := securecookie.CodecsFromPairs(keyPairs...)
+	for _, codec := range codecs {
+		if c, ok := codec.(*securecookie.SecureCookie); ok {
+			// the size is limited per chunk instead
+			c.MaxLength(0)
+		}
+	}
+	s := &chunkedCookieStore{
+		codecs: codecs,
+		options: &sessions.Options{
+			Path:   "/",
+			MaxAge: sessionExpire,
+		},
+	}
+	s.maxAge(s.options.MaxAge)
+	return s
+}
+
+type chunkedCookieStore struct {
+	codecs            []securecookie.Codec
+	options           *sessions.Options
+	partitionedOption bool
+}
+
+func (s *chunkedCookieStore) Options(options Options) {
+	s.options = options.sessionsOptions()
+	s.partitionedOption = options.Partitioned
+	s.maxAge(s.options.MaxAge)
+}
+
+func (s *chunkedCookieStore) partitioned() bool {
+	return s.partitionedOption
+}
+
+// maxAge sets the maximum age of the codecs, so expired cookies fail to decode.
+func (s *chunkedCookieStore) maxAge(age int) {
+	for _, codec := range s.codecs {
+		if c, ok := codec.(*securecookie.SecureCookie); ok {
+			c.MaxAge(age)
+		}
+	}
+}
+
+// Get returns a session for the given name after adding it to the registry.
+func (s *chunkedCookieStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns a session for the given name without adding it to the registry.
+func (s *chunkedCookieStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	options := *s.options
+	session.Options = &options
+	session.IsNew = true
+	var value strings.Builder
+	for i := 0; ; i++ {
+		c, err := r.Cookie(chunkName(name, i))
+		if err != nil {
+			break
+		}
+		value.WriteString(c.Value)
+	}
+	if value.Len() == 0 {
+		return session, nil
+	}
+	err := securecookie.DecodeMulti(name, value.String(), &session.Values, s.codecs...)
+	if err == nil {
+		session.IsNew = false
+	}
+	return session, err
+}
+
+// Save writes the session in as many chunks as needed and expires the chunks of the
+// request that are no longer used.
+func (s *chunkedCookieStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	var chunks []string
+	if session.Options.MaxAge >= 0 {
+		encoded, err := securecookie.EncodeMulti(session.Name(), session.Values, s.codecs...)
+		if err != nil {
+			return err
+		}
+		for len(encoded) > cookieChunkSize {
+			chunks = append(chunks, encoded[:cookieChunkSize])
+			encoded = encoded[cookieChunkSize:]
+		}
+		chunks = append(chunks, encoded)
+	}
+	for i, chunk := range chunks {
+		http.SetCookie(w, sessions.NewCookie(chunkName(session.Name(), i), chunk, session.Options))
+	}
+	expired := *session.Options
+	expired.MaxAge = -1
+	for i := len(chunks); i < requestChunks(r, session.Name()); i++ {
+		http.SetCookie(w, sessions.NewCookie(chunkName(session.Name(), i), "", &expired))
+	}
+	return nil
+}
+
+func chunkName(name string, i int) string {
+	return fmt.Sprintf("%s_%d", name, i)
+}
+
+// requestChunks returns the number of chunks the request has for the session name,
+// counting every chunk cookie even when the sequence has gaps.
+func requestChunks(r *http.Request, name string) int {
+	n := 0
+	for _, c := range r.Cookies() {
+		if !strings.HasPrefix(c.Name, name+"_") {
+			continue
+		}
+		if i, err := strconv.Atoi(c.Name[len(name)+1:]); err == nil && i >= n {
+			n = i + 1
+		}
+	}
+	return n
+}
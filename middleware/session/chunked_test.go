@@ -0,0 +1,94 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+var newChunkedCookieStore = func(_ *testing.T) SessionsStore {
+	return NewChunkedCookieStore([]byte("secret"))
+}
+
+func TestChunkedCookie_SessionGetSet(t *testing.T) {
+	sessionGetSet(t, newChunkedCookieStore)
+}
+
+func TestChunkedCookie_SessionDeleteKey(t *testing.T) {
+	sessionDeleteKey(t, newChunkedCookieStore)
+}
+
+func TestChunkedCookie_SessionFlashes(t *testing.T) {
+	sessionFlashes(t, newChunkedCookieStore)
+}
+
+func TestChunkedCookie_SessionClear(t *testing.T) {
+	sessionClear(t, newChunkedCookieStore)
+}
+
+func TestChunkedCookie_SessionSameSitePartitioned(t *testing.T) {
+	sessionSameSitePartitioned(t, newChunkedCookieStore)
+}
+
+func TestChunkedCookie_LargeSession(t *testing.T) {
+	r := gin.Default()
+	r.Use(New(sessionName, newChunkedCookieStore(t), nil))
+
+	claims := strings.Repeat("claim ", 2000)
+	r.GET("/set", func(c *gin.Context) {
+		session := Default(c)
+		session.Set("claims", claims)
+		session.Save()
+		c.String(200, ok)
+	})
+	r.GET("/shrink", func(c *gin.Context) {
+		session := Default(c)
+		if session.Get("claims") != claims {
+			t.Error("Chunked session reading failed")
+		}
+		session.Set("claims", ok)
+		session.Save()
+		c.String(200, ok)
+	})
+
+	res1 := httptest.NewRecorder()
+	req1, _ := http.NewRequest("GET", "/set", nil)
+	r.ServeHTTP(res1, req1)
+
+	cookies := res1.Result().Cookies()
+	if len(cookies) < 3 {
+		t.Fatalf("Expected the session split across several cookies, got %d", len(cookies))
+	}
+	for i, cookie := range cookies {
+		if cookie.Name != chunkName(sessionName, i) || len(cookie.String()) > 4096 {
+			t.Errorf("Unexpected chunk %d: %s (%d bytes)", i, cookie.Name, len(cookie.String()))
+		}
+	}
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/shrink", nil)
+	for _, cookie := range cookies {
+		req2.AddCookie(cookie)
+	}
+	r.ServeHTTP(res2, req2)
+
+	shrunk := res2.Result().Cookies()
+	if len(shrunk) != len(cookies) {
+		t.Fatalf("Expected %d cookies, got %d", len(cookies), len(shrunk))
+	}
+	if shrunk[0].Name != chunkName(sessionName, 0) || shrunk[0].MaxAge <= 0 {
+		t.Error("The shrunk session must be written to the first chunk:", shrunk[0])
+	}
+	for _, cookie := range shrunk[1:] {
+		if cookie.MaxAge >= 0 {
+			t.Error("Stale chunks must be expired:", cookie)
+		}
+	}
+}
+
+func TestChunkedCookie_SessionOptions(t *testing.T) {
+	sessionOptions(t, newChunkedCookieStore)
+}
@@ -28,3 +28,15 @@ func TestCookie_SessionClear(t *testing.T) {
 func TestCookie_SessionOptions(t *testing.T) {
 	sessionOptions(t, newCookieStore)
 }
+
+func TestCookie_SessionSameSitePartitioned(t *testing.T) {
+	sessionSameSitePartitioned(t, newCookieStore)
+}
+
+func TestCookie_SessionMany(t *testing.T) {
+	sessionMany(t, newCookieStore)
+}
+
+func TestCookie_SessionRegenerate(t *testing.T) {
+	sessionRegenerate(t, newCookieStore)
+}
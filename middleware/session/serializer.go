@@ -0,0 +1,325 @@
+package session
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+
+	"github.com/gorilla/sessions"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ugorji/go/codec"
+)
+
+var (
+	errUnknownKeyID = errors.New("SessionStore: the session was encrypted with an unknown key")
+	errCiphertext   = errors.New("SessionStore: the session ciphertext is malformed")
+)
+
+// MsgpackSerializer encode the session map to msgpack, unlike JSON it keeps non-string keys
+// and the Go type of numbers: an int stored with s.Set("n", 1) is read with s.Get("n").(int).
+// Numbers in nested slices and maps come back as int64 or float64, like with the plain format.
+type MsgpackSerializer struct{}
+
+// msgpackNumberTag is the msgpack extension type of msgpackNumber.
+const msgpackNumberTag = 1
+
+var msgpackHandle = newMsgpackHandle()
+
+func newMsgpackHandle() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{WriteExt: true}
+	// decode strings as string, not []byte, and integers as int64, whatever their sign
+	h.RawToString = true
+	h.SignedInteger = true
+	// SetBytesExt only fails for unnamed types
+	_ = h.SetBytesExt(reflect.TypeOf(msgpackNumber{}), msgpackNumberTag, msgpackNumberExt{})
+	return h
+}
+
+// Serialize using msgpack
+func (s MsgpackSerializer) Serialize(ss *sessions.Session) ([]byte, error) {
+	values := make(map[interface{}]interface{}, len(ss.Values))
+	for k, v := range ss.Values {
+		values[wrapNumber(k)] = wrapNumber(v)
+	}
+	var b []byte
+	err := codec.NewEncoderBytes(&b, msgpackHandle).Encode(values)
+	return b, err
+}
+
+// Deserialize back to map[interface{}]interface{}
+func (s MsgpackSerializer) Deserialize(d []byte, ss *sessions.Session) error {
+	values := make(map[interface{}]interface{})
+	if err := codec.NewDecoderBytes(d, msgpackHandle).Decode(&values); err != nil {
+		return err
+	}
+	for k, v := range values {
+		ss.Values[unwrapNumber(k)] = unwrapNumber(v)
+	}
+	return nil
+}
+
+// msgpackNumber keeps the Go type of a number msgpack would decode as int64 or float64,
+// it is written as an extension holding the kind and the bits of the number.
+type msgpackNumber struct {
+	kind reflect.Kind
+	bits uint64
+}
+
+func wrapNumber(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int:
+		return msgpackNumber{reflect.Int, uint64(n)}
+	case int8:
+		return msgpackNumber{reflect.Int8, uint64(n)}
+	case int16:
+		return msgpackNumber{reflect.Int16, uint64(n)}
+	case int32:
+		return msgpackNumber{reflect.Int32, uint64(n)}
+	case uint:
+		return msgpackNumber{reflect.Uint, uint64(n)}
+	case uint8:
+		return msgpackNumber{reflect.Uint8, uint64(n)}
+	case uint16:
+		return msgpackNumber{reflect.Uint16, uint64(n)}
+	case uint32:
+		return msgpackNumber{reflect.Uint32, uint64(n)}
+	case uint64:
+		return msgpackNumber{reflect.Uint64, n}
+	case float32:
+		return msgpackNumber{reflect.Float32, uint64(math.Float32bits(n))}
+	}
+	return v
+}
+
+func unwrapNumber(v interface{}) interface{} {
+	var n msgpackNumber
+	switch w := v.(type) {
+	case msgpackNumber:
+		n = w
+	case *msgpackNumber:
+		n = *w
+	default:
+		return v
+	}
+	switch n.kind {
+	case reflect.Int:
+		return int(n.bits)
+	case reflect.Int8:
+		return int8(n.bits)
+	case reflect.Int16:
+		return int16(n.bits)
+	case reflect.Int32:
+		return int32(n.bits)
+	case reflect.Uint:
+		return uint(n.bits)
+	case reflect.Uint8:
+		return uint8(n.bits)
+	case reflect.Uint16:
+		return uint16(n.bits)
+	case reflect.Uint32:
+		return uint32(n.bits)
+	case reflect.Uint64:
+		return n.bits
+	case reflect.Float32:
+		return math.Float32frombits(uint32(n.bits))
+	}
+	return int64(n.bits)
+}
+
+// msgpackNumberExt encodes a msgpackNumber to its kind followed by its bits.
+type msgpackNumberExt struct{}
+
+func (msgpackNumberExt) WriteExt(v interface{}) []byte {
+	var n msgpackNumber
+	switch w := v.(type) {
+	case msgpackNumber:
+		n = w
+	case *msgpackNumber:
+		n = *w
+	}
+	b := make([]byte, 9)
+	b[0] = byte(n.kind)
+	binary.BigEndian.PutUint64(b[1:], n.bits)
+	return b
+}
+
+func (msgpackNumberExt) ReadExt(dst interface{}, src []byte) {
+	n := dst.(*msgpackNumber)
+	if len(src) != 9 {
+		// not written by WriteExt, unwrapNumber returns it as an int64
+		n.kind = reflect.Int64
+		return
+	}
+	n.kind = reflect.Kind(src[0])
+	n.bits = binary.BigEndian.Uint64(src[1:])
+}
+
+// Keyring holds the AES keys of an EncryptedSerializer by ID. New sessions are encrypted
+// with the current key, the others are kept to decrypt sessions written before a rotation.
+type Keyring struct {
+	current string
+	aeads   map[string]cipher.AEAD
+}
+
+// NewKeyring creates a keyring encrypting with the key current of keys. Keys must be
+// 16, 24, or 32 bytes to select AES-128, AES-192, or AES-256, IDs at most 255 bytes.
+// To rotate, add a new key and make it current, drop the old key once its sessions expired.
+func NewKeyring(current string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("SessionStore: the current key %q is not in the keyring", current)
+	}
+	k := &Keyring{current: current, aeads: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if len(id) > 255 {
+			return nil, fmt.Errorf("SessionStore: the key ID %q is too long", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if k.aeads[id], err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// EncryptedSerializer encrypts the data of another serializer with AES-GCM. The ciphertext
+// is bound to the session ID, so it cannot be replayed under another session.
+type EncryptedSerializer struct {
+	serializer SessionSerializer
+	keyring    *Keyring
+}
+
+// NewEncryptedSerializer wraps serializer with AES-GCM encryption using keyring.
+func NewEncryptedSerializer(serializer SessionSerializer, keyring *Keyring) *EncryptedSerializer {
+	return &EncryptedSerializer{serializer: serializer, keyring: keyring}
+}
+
+// Serialize to: key ID length, key ID, nonce, ciphertext.
+func (s *EncryptedSerializer) Serialize(ss *sessions.Session) ([]byte, error) {
+	plain, err := s.serializer.Serialize(ss)
+	if err != nil {
+		return nil, err
+	}
+	id := s.keyring.current
+	aead := s.keyring.aeads[id]
+	b := make([]byte, 1+len(id)+aead.NonceSize(), 1+len(id)+aead.NonceSize()+len(plain)+aead.Overhead())
+	b[0] = byte(len(id))
+	copy(b[1:], id)
+	nonce := b[1+len(id):]
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(b, nonce, plain, []byte(ss.ID)), nil
+}
+
+// Deserialize decrypts with the key the data was encrypted with.
+func (s *EncryptedSerializer) Deserialize(d []byte, ss *sessions.Session) error {
+	if len(d) == 0 || len(d) < 1+int(d[0]) {
+		return errCiphertext
+	}
+	id, d := string(d[1:1+int(d[0])]), d[1+int(d[0]):]
+	aead, ok := s.keyring.aeads[id]
+	if !ok {
+		return errUnknownKeyID
+	}
+	if len(d) < aead.NonceSize() {
+		return errCiphertext
+	}
+	plain, err := aead.Open(nil, d[:aead.NonceSize()], d[aead.NonceSize():], []byte(ss.ID))
+	if err != nil {
+		return err
+	}
+	return s.serializer.Deserialize(plain, ss)
+}
+
+// Compression selects the algorithm of a CompressedSerializer.
+type Compression byte
+
+const (
+	CompressionGzip Compression = iota + 1
+	CompressionZstd
+)
+
+// compressedMagic starts compressed data, followed by the Compression. Other serializers never
+// write it: gob would encode the length as a single byte, msgpack -1 is not a map, and it would
+// take a 255 byte key ID starting with a zero byte for an EncryptedSerializer.
+const compressedMagic = "\xff\x00SC"
+
+// CompressedSerializer compresses the data of another serializer. Data written without
+// compression, or with another algorithm, is still read, so it can be enabled or changed
+// on a live store.
+type CompressedSerializer struct {
+	serializer  SessionSerializer
+	compression Compression
+	encoder     *zstd.Encoder
+	decoder     *zstd.Decoder
+}
+
+// NewCompressedSerializer wraps serializer with gzip or zstd compression.
+func NewCompressedSerializer(serializer SessionSerializer, compression Compression) *CompressedSerializer {
+	// with nil writers and readers only EncodeAll and DecodeAll are used, which do not fail
+	encoder, _ := zstd.NewWriter(nil)
+	decoder, _ := zstd.NewReader(nil)
+	return &CompressedSerializer{serializer: serializer, compression: compression, encoder: encoder, decoder: decoder}
+}
+
+// Serialize to compressedMagic and the Compression followed by the compressed data.
+func (s *CompressedSerializer) Serialize(ss *sessions.Session) ([]byte, error) {
+	plain, err := s.serializer.Serialize(ss)
+	if err != nil {
+		return nil, err
+	}
+	switch s.compression {
+	case CompressionGzip:
+		buf := bytes.NewBuffer(append([]byte(compressedMagic), byte(CompressionGzip)))
+		zw := gzip.NewWriter(buf)
+		if _, err = zw.Write(plain); err != nil {
+			return nil, err
+		}
+		if err = zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		return s.encoder.EncodeAll(plain, append([]byte(compressedMagic), byte(CompressionZstd))), nil
+	}
+	return nil, fmt.Errorf("SessionStore: unknown compression %d", s.compression)
+}
+
+// Deserialize decompresses according to the Compression after compressedMagic.
+func (s *CompressedSerializer) Deserialize(d []byte, ss *sessions.Session) error {
+	if len(d) <= len(compressedMagic) || !bytes.HasPrefix(d, []byte(compressedMagic)) {
+		// not compressed
+		return s.serializer.Deserialize(d, ss)
+	}
+	compression, data := Compression(d[len(compressedMagic)]), d[len(compressedMagic)+1:]
+	switch compression {
+	case CompressionGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		plain, err := io.ReadAll(zr)
+		if err != nil {
+			return err
+		}
+		return s.serializer.Deserialize(plain, ss)
+	case CompressionZstd:
+		plain, err := s.decoder.DecodeAll(data, nil)
+		if err != nil {
+			return err
+		}
+		return s.serializer.Deserialize(plain, ss)
+	}
+	return fmt.Errorf("SessionStore: unknown compression %d", compression)
+}
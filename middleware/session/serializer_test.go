@@ -0,0 +1,195 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/donetkit/contrib/utils/cache"
+	"github.com/gorilla/sessions"
+)
+
+func newTestSession(id string) *sessions.Session {
+	ss := sessions.NewSession(nil, sessionName)
+	ss.ID = id
+	return ss
+}
+
+func roundTrip(t *testing.T, s SessionSerializer, values map[interface{}]interface{}) map[interface{}]interface{} {
+	ss := newTestSession("ID")
+	ss.Values = values
+	b, err := s.Serialize(ss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := newTestSession("ID")
+	if err = s.Deserialize(b, out); err != nil {
+		t.Fatal(err)
+	}
+	return out.Values
+}
+
+func TestMsgpackSerializer(t *testing.T) {
+	values := map[interface{}]interface{}{"user_id": int64(42), "name": "alice", int64(7): true}
+	if got := roundTrip(t, MsgpackSerializer{}, values); !reflect.DeepEqual(got, values) {
+		t.Errorf("Deserialize = %#v, want %#v", got, values)
+	}
+
+	// numbers keep their Go type
+	values = map[interface{}]interface{}{
+		"n": 1, "i8": int8(-8), "u": uint16(2), 3: int32(-3), uint(4): uint64(1 << 63),
+		"f": float32(1.5), "b": uint8(255), "list": []interface{}{int64(1)},
+	}
+	if got := roundTrip(t, MsgpackSerializer{}, values); !reflect.DeepEqual(got, values) {
+		t.Errorf("Deserialize = %#v, want %#v", got, values)
+	}
+}
+
+func newTestKeyring(t *testing.T, current string) *Keyring {
+	k, err := NewKeyring(current, map[string][]byte{
+		"2023": bytes.Repeat([]byte{1}, 32),
+		"2024": bytes.Repeat([]byte{2}, 16),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestEncryptedSerializer(t *testing.T) {
+	s := NewEncryptedSerializer(JSONSerializer{}, newTestKeyring(t, "2023"))
+	values := map[interface{}]interface{}{"key": ok}
+	if got := roundTrip(t, s, values); !reflect.DeepEqual(got, values) {
+		t.Errorf("Deserialize = %#v, want %#v", got, values)
+	}
+
+	ss := newTestSession("ID")
+	ss.Values = values
+	b, _ := s.Serialize(ss)
+	if bytes.Contains(b, []byte(ok)) {
+		t.Error("The session data must be encrypted")
+	}
+	if err := s.Deserialize(b, newTestSession("OTHER")); err == nil {
+		t.Error("The ciphertext must be bound to the session ID")
+	}
+	b[len(b)-1] ^= 1
+	if err := s.Deserialize(b, newTestSession("ID")); err == nil {
+		t.Error("Tampered ciphertext must be rejected")
+	}
+	if err := s.Deserialize([]byte{9, 'x'}, newTestSession("ID")); err == nil {
+		t.Error("Malformed ciphertext must be rejected")
+	}
+}
+
+func TestEncryptedSerializer_Rotation(t *testing.T) {
+	old := NewEncryptedSerializer(JSONSerializer{}, newTestKeyring(t, "2023"))
+	rotated := NewEncryptedSerializer(JSONSerializer{}, newTestKeyring(t, "2024"))
+
+	ss := newTestSession("ID")
+	ss.Values["key"] = ok
+	b, _ := old.Serialize(ss)
+	out := newTestSession("ID")
+	if err := rotated.Deserialize(b, out); err != nil || out.Values["key"] != ok {
+		t.Error("Sessions encrypted with a previous key must still be read:", err)
+	}
+
+	b, _ = rotated.Serialize(ss)
+	if string(b[1:1+b[0]]) != "2024" {
+		t.Error("Sessions must be encrypted with the current key")
+	}
+
+	if _, err := NewKeyring("2025", map[string][]byte{"2024": make([]byte, 32)}); err == nil {
+		t.Error("The current key must be in the keyring")
+	}
+	if _, err := NewKeyring("2024", map[string][]byte{"2024": make([]byte, 10)}); err == nil {
+		t.Error("Invalid AES key sizes must be rejected")
+	}
+}
+
+func TestCompressedSerializer(t *testing.T) {
+	values := map[interface{}]interface{}{"data": string(bytes.Repeat([]byte("session "), 1000))}
+	plain, _ := JSONSerializer{}.Serialize(&sessions.Session{Values: values})
+	for _, compression := range []Compression{CompressionGzip, CompressionZstd} {
+		s := NewCompressedSerializer(JSONSerializer{}, compression)
+		if got := roundTrip(t, s, values); !reflect.DeepEqual(got, values) {
+			t.Errorf("%d: Deserialize = %#v, want %#v", compression, got, values)
+		}
+		b, _ := s.Serialize(&sessions.Session{Values: values})
+		if len(b) >= len(plain) {
+			t.Errorf("%d: compressed %d bytes to %d", compression, len(plain), len(b))
+		}
+
+		out := newTestSession("ID")
+		if err := s.Deserialize(plain, out); err != nil || !reflect.DeepEqual(out.Values, values) {
+			t.Errorf("%d: uncompressed data must still be read: %v", compression, err)
+		}
+	}
+
+	// data starting with the former one byte markers is not mistaken for compressed data
+	for _, id := range []string{"\x01", "\x02\x02"} {
+		keyring, err := NewKeyring(id, map[string][]byte{id: make([]byte, 16)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		encrypted := NewEncryptedSerializer(JSONSerializer{}, keyring)
+		b, _ := encrypted.Serialize(&sessions.Session{ID: "ID", Values: values})
+		s := NewCompressedSerializer(encrypted, CompressionGzip)
+		out := newTestSession("ID")
+		if err := s.Deserialize(b, out); err != nil || !reflect.DeepEqual(out.Values, values) {
+			t.Errorf("key ID %q: uncompressed data must still be read: %v", id, err)
+		}
+	}
+}
+
+// jsonCache round-trips values through JSON like the redis cache does.
+type jsonCache struct {
+	*memoryCache
+}
+
+func (c jsonCache) WithDB(int) cache.ICache { return c }
+
+func (c jsonCache) WithContext(context.Context) cache.ICache { return c }
+
+func (c jsonCache) Set(key string, val interface{}, expire time.Duration) error {
+	b, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return c.memoryCache.Set(key, string(b), expire)
+}
+
+func (c jsonCache) Get(key string) interface{} {
+	var v interface{}
+	if b, ok := c.memoryCache.Get(key).(string); ok {
+		json.Unmarshal([]byte(b), &v)
+	}
+	return v
+}
+
+func TestCacheStore_BinarySerializer(t *testing.T) {
+	mc := newMemoryCache()
+	store := NewCacheStore(jsonCache{mc}, []byte("secret"))
+	store.SetSerializer(NewEncryptedSerializer(NewCompressedSerializer(MsgpackSerializer{}, CompressionZstd), newTestKeyring(t, "2024")))
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	res := httptest.NewRecorder()
+	session, _ := store.New(req, sessionName)
+	session.Values["user_id"] = int64(42)
+	if err := store.Save(req, res, session); err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	session, err := store.New(req, sessionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.IsNew || session.Values["user_id"] != int64(42) {
+		t.Errorf("Values = %#v, want the binary session to survive the cache", session.Values)
+	}
+}
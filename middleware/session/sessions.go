@@ -3,6 +3,7 @@ package session
 import (
 	"github.com/donetkit/contrib-log/glog"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/context"
@@ -11,6 +12,7 @@ import (
 
 const (
 	DefaultKey  = "github.com/gin-gonic/contrib/session"
+	ManyKey     = "github.com/gin-gonic/contrib/session/many"
 	errorFormat = "[session] ERROR! %s\n"
 )
 
@@ -30,6 +32,44 @@ type Options struct {
 	MaxAge   int
 	Secure   bool
 	HttpOnly bool
+	// SameSite restricts cross-site requests, http.SameSiteNoneMode also requires Secure.
+	// Defaults to http.SameSiteDefaultMode.
+	SameSite http.SameSite
+	// Partitioned stores the cookie in partitioned storage (CHIPS), it also requires Secure.
+	Partitioned bool
+}
+
+// sessionsOptions converts the options to gorilla sessions.Options,
+// Partitioned has no counterpart there and is applied on Save.
+func (o Options) sessionsOptions() *sessions.Options {
+	return &sessions.Options{
+		Path:     o.Path,
+		Domain:   o.Domain,
+		MaxAge:   o.MaxAge,
+		Secure:   o.Secure,
+		HttpOnly: o.HttpOnly,
+		SameSite: o.SameSite,
+	}
+}
+
+// partitionedStore is implemented by the stores of this package to report their Partitioned option.
+type partitionedStore interface {
+	partitioned() bool
+}
+
+// regenerator is implemented by stores that keep the session under an ID, see CacheStore.Regenerate.
+type regenerator interface {
+	Regenerate(r *http.Request, session *sessions.Session) error
+}
+
+// partitionCookies adds the Partitioned attribute to the Set-Cookie headers from index from on.
+func partitionCookies(header http.Header, from int) {
+	cookies := header["Set-Cookie"]
+	for i := from; i < len(cookies); i++ {
+		if !strings.Contains(cookies[i], "; Partitioned") {
+			cookies[i] += "; Partitioned"
+		}
+	}
 }
 
 // Session Wraps thinly gorilla-session methods.
@@ -53,19 +93,45 @@ type Session interface {
 	Flashes(vars ...string) []interface{}
 	// Options sets configuration for a session.
 	Options(Options)
+	// Regenerate issues a new session ID while keeping the values, the session
+	// stored under the old ID is removed. Call Save to write the new ID.
+	Regenerate() error
 	// Save saves all session used during the current request.
 	Save() error
 }
 
 func New(name string, store SessionsStore, logger glog.ILogger) gin.HandlerFunc {
+	var entry glog.ILoggerEntry
+	if logger != nil {
+		entry = logger.WithField("Session", "Session")
+	}
 	return func(c *gin.Context) {
-		s := &session{name, c.Request, store, nil, false, c.Writer, logger.WithField("Session", "Session")}
+		s := &session{name: name, request: c.Request, store: store, writer: c.Writer, logger: entry}
 		c.Set(DefaultKey, s)
 		defer context.Clear(c.Request)
 		c.Next()
 	}
 }
 
+// Many registers a session for each of names, all kept in store. Use it once per store to have
+// several sessions with different stores and options in one request, they are read with DefaultMany.
+func Many(names []string, store SessionsStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		many := make(map[string]Session, len(names))
+		if v, ok := c.Get(ManyKey); ok {
+			for name, s := range v.(map[string]Session) {
+				many[name] = s
+			}
+		}
+		for _, name := range names {
+			many[name] = &session{name: name, request: c.Request, store: store, writer: c.Writer}
+		}
+		c.Set(ManyKey, many)
+		defer context.Clear(c.Request)
+		c.Next()
+	}
+}
+
 type session struct {
 	name    string
 	request *http.Request
@@ -74,6 +140,8 @@ type session struct {
 	written bool
 	writer  http.ResponseWriter
 	logger  glog.ILoggerEntry
+	// partitionedOption is set by Options and overrides the Partitioned option of the store
+	partitionedOption *bool
 }
 
 func (s *session) Get(key interface{}) interface{} {
@@ -107,26 +175,46 @@ func (s *session) Flashes(vars ...string) []interface{} {
 }
 
 func (s *session) Options(options Options) {
-	s.Session().Options = &sessions.Options{
-		Path:     options.Path,
-		Domain:   options.Domain,
-		MaxAge:   options.MaxAge,
-		Secure:   options.Secure,
-		HttpOnly: options.HttpOnly,
+	s.Session().Options = options.sessionsOptions()
+	s.partitionedOption = &options.Partitioned
+}
+
+func (s *session) Regenerate() error {
+	// stores without a session ID, like the cookie store, only need the cookie to be re-encoded
+	if store, ok := s.store.(regenerator); ok {
+		if err := store.Regenerate(s.request, s.Session()); err != nil {
+			return err
+		}
 	}
+	s.written = true
+	return nil
 }
 
 func (s *session) Save() error {
 	if s.Written() {
+		n := len(s.writer.Header().Values("Set-Cookie"))
 		e := s.Session().Save(s.request, s.writer)
 		if e == nil {
 			s.written = false
+			if s.partitioned() {
+				partitionCookies(s.writer.Header(), n)
+			}
 		}
 		return e
 	}
 	return nil
 }
 
+func (s *session) partitioned() bool {
+	if s.partitionedOption != nil {
+		return *s.partitionedOption
+	}
+	if store, ok := s.store.(partitionedStore); ok {
+		return store.partitioned()
+	}
+	return false
+}
+
 func (s *session) Session() *sessions.Session {
 	if s.session == nil {
 		var err error
@@ -148,3 +236,8 @@ func (s *session) Written() bool {
 func Default(c *gin.Context) Session {
 	return c.MustGet(DefaultKey).(Session)
 }
+
+// DefaultMany shortcut to get the session registered as name by Many
+func DefaultMany(c *gin.Context, name string) Session {
+	return c.MustGet(ManyKey).(map[string]Session)[name]
+}
@@ -217,3 +217,150 @@ func sessionOptions(t *testing.T, newStore storeFactory) {
 		t.Error("Error writing domain with options:", s[1])
 	}
 }
+
+func sessionSameSitePartitioned(t *testing.T, newStore storeFactory) {
+	r := gin.Default()
+	store := newStore(t)
+	store.Options(Options{
+		Path:        "/",
+		Secure:      true,
+		SameSite:    http.SameSiteNoneMode,
+		Partitioned: true,
+	})
+	r.Use(New(sessionName, store, nil))
+
+	r.GET("/widget", func(c *gin.Context) {
+		session := Default(c)
+		session.Set("key", ok)
+		session.Save()
+		c.String(200, ok)
+	})
+	r.GET("/admin", func(c *gin.Context) {
+		session := Default(c)
+		session.Set("key", ok)
+		session.Options(Options{
+			Path:     "/admin",
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+		})
+		session.Save()
+		c.String(200, ok)
+	})
+
+	res1 := httptest.NewRecorder()
+	req1, _ := http.NewRequest("GET", "/widget", nil)
+	r.ServeHTTP(res1, req1)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/admin", nil)
+	r.ServeHTTP(res2, req2)
+
+	cookie := res1.Header().Get("Set-Cookie")
+	if !strings.Contains(cookie, "; SameSite=None") || !strings.HasSuffix(cookie, "; Partitioned") {
+		t.Error("Error writing SameSite and Partitioned with options:", cookie)
+	}
+
+	cookie = res2.Header().Get("Set-Cookie")
+	if !strings.Contains(cookie, "; SameSite=Strict") || strings.Contains(cookie, "Partitioned") {
+		t.Error("Error writing SameSite with session options:", cookie)
+	}
+}
+
+func sessionMany(t *testing.T, newStore storeFactory) {
+	r := gin.Default()
+	flash, auth := newStore(t), newStore(t)
+	flash.Options(Options{Path: "/", MaxAge: 60})
+	auth.Options(Options{Path: "/", MaxAge: 3600})
+	r.Use(Many([]string{"flash"}, flash), Many([]string{"auth", "prefs"}, auth))
+
+	r.GET("/set", func(c *gin.Context) {
+		DefaultMany(c, "flash").AddFlash(ok)
+		DefaultMany(c, "flash").Save()
+		DefaultMany(c, "auth").Set("user", "alice")
+		DefaultMany(c, "auth").Save()
+		c.String(200, ok)
+	})
+
+	r.GET("/get", func(c *gin.Context) {
+		if flashes := DefaultMany(c, "flash").Flashes(); len(flashes) != 1 || flashes[0] != ok {
+			t.Error("Flash session writing failed:", flashes)
+		}
+		if DefaultMany(c, "auth").Get("user") != "alice" {
+			t.Error("Auth session writing failed")
+		}
+		if DefaultMany(c, "prefs").Get("user") != nil {
+			t.Error("Sessions of the same store must be kept apart")
+		}
+		c.String(200, ok)
+	})
+
+	res1 := httptest.NewRecorder()
+	req1, _ := http.NewRequest("GET", "/set", nil)
+	r.ServeHTTP(res1, req1)
+
+	cookies := res1.Header().Values("Set-Cookie")
+	if len(cookies) != 2 {
+		t.Fatal("Expected a cookie per saved session:", cookies)
+	}
+	if !strings.HasPrefix(cookies[0], "flash=") || !strings.Contains(cookies[0], "Max-Age=60") {
+		t.Error("Error writing flash session with its store options:", cookies[0])
+	}
+	if !strings.HasPrefix(cookies[1], "auth=") || !strings.Contains(cookies[1], "Max-Age=3600") {
+		t.Error("Error writing auth session with its store options:", cookies[1])
+	}
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/get", nil)
+	for _, cookie := range cookies {
+		req2.Header.Add("Cookie", strings.Split(cookie, ";")[0])
+	}
+	r.ServeHTTP(res2, req2)
+}
+
+func sessionRegenerate(t *testing.T, newStore storeFactory) {
+	r := gin.Default()
+	r.Use(New(sessionName, newStore(t), nil))
+
+	r.GET("/set", func(c *gin.Context) {
+		session := Default(c)
+		session.Set("key", ok)
+		session.Save()
+		c.String(200, ok)
+	})
+
+	r.GET("/login", func(c *gin.Context) {
+		session := Default(c)
+		if err := session.Regenerate(); err != nil {
+			t.Error("Session regenerating failed:", err)
+		}
+		session.Set("user", "alice")
+		session.Save()
+		c.String(200, ok)
+	})
+
+	r.GET("/get", func(c *gin.Context) {
+		session := Default(c)
+		if session.Get("key") != ok || session.Get("user") != "alice" {
+			t.Error("Session values must be kept after Regenerate")
+		}
+		c.String(200, ok)
+	})
+
+	res1 := httptest.NewRecorder()
+	req1, _ := http.NewRequest("GET", "/set", nil)
+	r.ServeHTTP(res1, req1)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/login", nil)
+	req2.Header.Set("Cookie", res1.Header().Get("Set-Cookie"))
+	r.ServeHTTP(res2, req2)
+
+	if res2.Header().Get("Set-Cookie") == "" || res2.Header().Get("Set-Cookie") == res1.Header().Get("Set-Cookie") {
+		t.Error("Regenerate must issue a new session cookie")
+	}
+
+	res3 := httptest.NewRecorder()
+	req3, _ := http.NewRequest("GET", "/get", nil)
+	req3.Header.Set("Cookie", res2.Header().Get("Set-Cookie"))
+	r.ServeHTTP(res3, req3)
+}
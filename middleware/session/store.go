@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/base32"
+	"encoding/base64"
 	"encoding/gob"
 	"encoding/json"
 	"errors"
@@ -12,13 +13,21 @@ import (
 	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // Amount of time for cookies/redis keys to expire.
 var sessionExpire = 86400 * 30
 
+var timeNow = time.Now
+
+// base64Prefix marks binary session data stored as base64.
+const base64Prefix = "base64:"
+
 // SessionSerializer provides an interface hook for alternative serializers
 type SessionSerializer interface {
 	Deserialize(d []byte, ss *sessions.Session) error
@@ -84,6 +93,18 @@ type CacheStore struct {
 	maxLength     int
 	keyPrefix     string
 	serializer    SessionSerializer
+	userKey       string
+	// sliding refreshes the TTL when the session is read, at most once per slideThrottle seconds
+	sliding        bool
+	slideThrottle  int
+	absoluteMaxAge int
+}
+
+// SessionInfo describes a session of a user, see CacheStore.ListSessions.
+type SessionInfo struct {
+	ID string
+	// Saved is when the session was last saved.
+	Saved time.Time
 }
 
 // SetMaxLength sets CacheStore.maxLength if the `l` argument is greater or equal 0
@@ -102,6 +123,30 @@ func (s *CacheStore) SetKeyPrefix(p string) {
 	s.keyPrefix = p
 }
 
+// SetUserKey binds sessions to users: every session holding a value under key, e.g. "user_id",
+// is recorded in a per-user index, so ListSessions, CountSessions and RevokeAll can find it.
+// The cache must support the hash commands. Default: "", sessions are not indexed.
+func (s *CacheStore) SetUserKey(key string) {
+	s.userKey = key
+}
+
+// SetSlidingExpiration makes MaxAge an idle timeout: the TTL of the session is refreshed
+// whenever it is read, not only when it is written, at most once per throttle seconds.
+// The cookie then lasts until the absolute lifetime set with SetAbsoluteMaxAge, or until
+// the browser is closed when there is none. A read only refreshes the small metadata record
+// of the session, the values are stored for the absolute lifetime, or for the default
+// expiration of 30 days when there is none, and are rewritten when that runs out.
+func (s *CacheStore) SetSlidingExpiration(throttle int) {
+	s.sliding = true
+	s.slideThrottle = throttle
+}
+
+// SetAbsoluteMaxAge caps the lifetime of a session, in seconds since it was first saved,
+// regardless of how active it is. Set it to 0 for no restriction, which is the default.
+func (s *CacheStore) SetAbsoluteMaxAge(v int) {
+	s.absoluteMaxAge = v
+}
+
 // SetSerializer sets the serializer
 func (s *CacheStore) SetSerializer(ss SessionSerializer) {
 	s.serializer = ss
@@ -171,6 +216,9 @@ func (s *CacheStore) New(r *http.Request, name string) (*sessions.Session, error
 		if err == nil {
 			ok, err = s.load(r.Context(), session)
 			session.IsNew = !(err == nil && ok) // not new if no error and data available
+			if !session.IsNew {
+				err = s.touch(r.Context(), session)
+			}
 		}
 	}
 	return session, err
@@ -187,26 +235,190 @@ func (s *CacheStore) Save(r *http.Request, w http.ResponseWriter, session *sessi
 	} else {
 		// Build an alphanumeric key for the redis store.
 		if session.ID == "" {
-			session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+			session.ID = newSessionID()
 		}
-		if err := s.save(r.Context(), session); err != nil {
+		meta, err := s.save(r.Context(), session)
+		if err != nil {
 			return err
 		}
 		encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
 		if err != nil {
 			return err
 		}
-		http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+		http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, s.cookieOptions(session, meta)))
 	}
 	return nil
 }
 
+// cookieOptions returns the options of the session cookie, which outlives the idle timeout
+// in sliding mode and never outlives the absolute lifetime.
+func (s *CacheStore) cookieOptions(session *sessions.Session, meta sessionMeta) *sessions.Options {
+	options := *session.Options
+	if s.sliding {
+		options.MaxAge = 0
+	}
+	if remaining := s.remaining(meta); remaining > 0 && (options.MaxAge == 0 || remaining < options.MaxAge) {
+		options.MaxAge = remaining
+	}
+	return &options
+}
+
+// touch enforces the absolute lifetime of a loaded session and slides its expiration, which only
+// rewrites the metadata of the session. An expired session is removed and replaced by an empty one.
+func (s *CacheStore) touch(ctx context.Context, session *sessions.Session) error {
+	if !s.sliding && s.absoluteMaxAge <= 0 {
+		return nil
+	}
+	meta, ok := s.meta(ctx, session.ID)
+	if !ok || (s.absoluteMaxAge > 0 && s.remaining(meta) <= 0) {
+		// the metadata expires with the idle timeout
+		if err := s.delete(ctx, session); err != nil {
+			return err
+		}
+		session.ID = ""
+		session.Values = make(map[interface{}]interface{})
+		session.IsNew = true
+		return nil
+	}
+	now := timeNow().Unix()
+	if !s.sliding || now-meta.touched < int64(s.slideThrottle) {
+		return nil
+	}
+	age := s.age(session, meta)
+	if meta.expires < now+int64(age) {
+		// the values would expire before the session
+		_, err := s.save(ctx, session)
+		return err
+	}
+	meta.touched = now
+	return s.Cache.WithContext(ctx).Set(s.metaKey(session.ID), meta.String(), time.Duration(age)*time.Second)
+}
+
+// age returns the seconds the session lives without being saved or read, capped by the absolute lifetime.
+func (s *CacheStore) age(session *sessions.Session, meta sessionMeta) int {
+	age := session.Options.MaxAge
+	if age == 0 {
+		age = s.DefaultMaxAge
+	}
+	if remaining := s.remaining(meta); remaining > 0 && remaining < age {
+		age = remaining
+	}
+	return age
+}
+
+// remaining returns the seconds left of the absolute lifetime, 0 when there is none.
+func (s *CacheStore) remaining(meta sessionMeta) int {
+	if s.absoluteMaxAge <= 0 {
+		return 0
+	}
+	if meta.created == 0 {
+		return s.absoluteMaxAge
+	}
+	return int(meta.created + int64(s.absoluteMaxAge) - timeNow().Unix())
+}
+
+// meta reads the metadata kept for the session in sliding mode or with an absolute lifetime.
+func (s *CacheStore) meta(ctx context.Context, id string) (sessionMeta, bool) {
+	if id == "" {
+		return sessionMeta{}, false
+	}
+	v, ok := s.Cache.WithContext(ctx).Get(s.metaKey(id)).(string)
+	if !ok {
+		return sessionMeta{}, false
+	}
+	return parseSessionMeta(v)
+}
+
+// Regenerate gives the session a new ID and removes the data stored under the old one,
+// the values are kept and stored under the new ID on the next Save.
+// Call it after login to prevent session fixation.
+func (s *CacheStore) Regenerate(r *http.Request, session *sessions.Session) error {
+	meta, ok := s.meta(r.Context(), session.ID)
+	if session.ID != "" {
+		if err := s.delete(r.Context(), session); err != nil {
+			return err
+		}
+	}
+	session.ID = newSessionID()
+	if ok {
+		// keep the absolute lifetime, the values are stored on the next Save
+		meta.expires = 0
+		return s.Cache.WithContext(r.Context()).Set(s.metaKey(session.ID), meta.String(), time.Duration(s.age(session, meta))*time.Second)
+	}
+	return nil
+}
+
+// ListSessions returns the live sessions of userID, sessions that expired or were bound to
+// another user meanwhile are removed from the index. It requires SetUserKey.
+func (s *CacheStore) ListSessions(ctx context.Context, userID string) []SessionInfo {
+	db := s.Cache.WithContext(ctx)
+	var (
+		list  []SessionInfo
+		stale []string
+	)
+	for id, saved := range db.HashAll(s.userIndexKey(userID)) {
+		session := sessions.NewSession(s, "")
+		session.ID = id
+		if ok, err := s.load(ctx, session); !ok || err != nil || s.userID(session) != userID {
+			stale = append(stale, id)
+			continue
+		}
+		unix, _ := strconv.ParseInt(saved, 10, 64)
+		list = append(list, SessionInfo{ID: id, Saved: time.Unix(unix, 0)})
+	}
+	if len(stale) > 0 {
+		db.HashDel(s.userIndexKey(userID), stale...)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Saved.After(list[j].Saved)
+	})
+	return list
+}
+
+// CountSessions returns the number of live sessions of userID. It requires SetUserKey.
+func (s *CacheStore) CountSessions(ctx context.Context, userID string) int {
+	return len(s.ListSessions(ctx, userID))
+}
+
+// RevokeSession removes the session with the given ID, the next request carrying its cookie
+// gets a new, empty session.
+func (s *CacheStore) RevokeSession(ctx context.Context, id string) error {
+	session := sessions.NewSession(s, "")
+	session.ID = id
+	if ok, _ := s.load(ctx, session); !ok {
+		// nothing to look up the user by, the index is cleaned up by ListSessions
+		s.Cache.WithContext(ctx).Delete(s.keyPrefix+id, s.metaKey(id))
+		return nil
+	}
+	return s.delete(ctx, session)
+}
+
+// RevokeAll removes every session of userID and returns how many were removed.
+// It requires SetUserKey.
+func (s *CacheStore) RevokeAll(ctx context.Context, userID string) int {
+	db := s.Cache.WithContext(ctx)
+	ids := db.HashKeys(s.userIndexKey(userID))
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, s.keyPrefix+id)
+	}
+	var n int64
+	if len(keys) > 0 {
+		n = db.Delete(keys...)
+		for _, id := range ids {
+			db.Delete(s.metaKey(id))
+		}
+	}
+	db.Delete(s.userIndexKey(userID))
+	return int(n)
+}
+
 // Delete removes the session from redis, and sets the cookie to expire.
 //
 // WARNING: This method should be considered deprecated since it is not exposed via the gorilla/session interface.
 // Set session.Options.MaxAge = -1 and call Save instead. - July 18th, 2013
 func (s *CacheStore) Delete(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
-	s.Cache.WithContext(r.Context()).Delete(s.keyPrefix + session.ID)
+	s.Cache.WithContext(r.Context()).Delete(s.keyPrefix+session.ID, s.metaKey(session.ID))
 	// Set cookie to expire.
 	options := *session.Options
 	options.MaxAge = -1
@@ -218,20 +430,50 @@ func (s *CacheStore) Delete(r *http.Request, w http.ResponseWriter, session *ses
 	return nil
 }
 
-// save stores the session in redis.
-func (s *CacheStore) save(ctx context.Context, session *sessions.Session) error {
+// save stores the session in redis, and its metadata in sliding mode or with an absolute lifetime.
+// In sliding mode the values outlive the idle timeout, which is kept by the metadata.
+func (s *CacheStore) save(ctx context.Context, session *sessions.Session) (sessionMeta, error) {
+	var meta sessionMeta
+	tracked := s.sliding || s.absoluteMaxAge > 0
+	now := timeNow().Unix()
+	if tracked {
+		meta, _ = s.meta(ctx, session.ID)
+		if meta.created == 0 {
+			meta.created = now
+		}
+		meta.touched = now
+	}
 	b, err := s.serializer.Serialize(session)
 	if err != nil {
-		return err
+		return meta, err
 	}
 	if s.maxLength != 0 && len(b) > s.maxLength {
-		return errors.New("SessionStore: the value to store is too big")
+		return meta, errors.New("SessionStore: the value to store is too big")
 	}
-	age := session.Options.MaxAge
-	if age == 0 {
-		age = s.DefaultMaxAge
+	age := s.age(session, meta)
+	ttl := age
+	if s.sliding {
+		if ttl = s.remaining(meta); ttl <= 0 {
+			ttl = sessionExpire
+			if age > ttl {
+				ttl = age
+			}
+		}
+	}
+	db := s.Cache.WithContext(ctx)
+	if err = db.Set(s.keyPrefix+session.ID, encodePayload(b), time.Duration(ttl)*time.Second); err != nil {
+		return meta, err
+	}
+	if tracked {
+		meta.expires = now + int64(ttl)
+		if err = db.Set(s.metaKey(session.ID), meta.String(), time.Duration(age)*time.Second); err != nil {
+			return meta, err
+		}
+	}
+	if user := s.userID(session); user != "" {
+		db.HashSet(s.userIndexKey(user), session.ID, timeNow().Unix())
 	}
-	return s.Cache.WithContext(ctx).Set(s.keyPrefix+session.ID, string(b), time.Duration(age)*time.Second)
+	return meta, nil
 }
 
 // load reads the session from redis.
@@ -239,13 +481,87 @@ func (s *CacheStore) save(ctx context.Context, session *sessions.Session) error
 func (s *CacheStore) load(ctx context.Context, session *sessions.Session) (bool, error) {
 	data := s.Cache.WithContext(ctx).Get(s.keyPrefix + session.ID)
 	if b, ok := data.(string); ok {
-		return true, s.serializer.Deserialize([]byte(b), session)
+		d, err := decodePayload(b)
+		if err != nil {
+			return true, err
+		}
+		return true, s.serializer.Deserialize(d, session)
 	}
 	return false, errors.New("not fond session")
 }
 
 // delete removes keys from redis if MaxAge<0
 func (s *CacheStore) delete(ctx context.Context, session *sessions.Session) error {
-	s.Cache.WithContext(ctx).Delete(s.keyPrefix + session.ID)
+	s.Cache.WithContext(ctx).Delete(s.keyPrefix+session.ID, s.metaKey(session.ID))
+	if user := s.userID(session); user != "" {
+		s.Cache.WithContext(ctx).HashDel(s.userIndexKey(user), session.ID)
+	}
 	return nil
 }
+
+// userID returns the user the session is bound to, or "" when SetUserKey is not used.
+func (s *CacheStore) userID(session *sessions.Session) string {
+	if s.userKey == "" {
+		return ""
+	}
+	switch v := session.Values[s.userKey].(type) {
+	case nil:
+		return ""
+	case float64:
+		// numbers read back by the JSONSerializer
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// encodePayload turns serialized data into a string the cache stores unchanged, the redis
+// cache JSON-encodes values, which would mangle binary data such as gob, msgpack or ciphertext.
+func encodePayload(b []byte) string {
+	if utf8.Valid(b) && !bytes.HasPrefix(b, []byte(base64Prefix)) {
+		return string(b)
+	}
+	return base64Prefix + base64.StdEncoding.EncodeToString(b)
+}
+
+// decodePayload reverses encodePayload.
+func decodePayload(s string) ([]byte, error) {
+	if strings.HasPrefix(s, base64Prefix) {
+		return base64.StdEncoding.DecodeString(s[len(base64Prefix):])
+	}
+	return []byte(s), nil
+}
+
+// sessionMeta is kept by CacheStore next to a session for the sliding expiration and the
+// absolute lifetime, out of the session values. Times are unix seconds, expires is when the
+// stored values expire.
+type sessionMeta struct {
+	created int64
+	touched int64
+	expires int64
+}
+
+func (m sessionMeta) String() string {
+	return fmt.Sprintf("%d %d %d", m.created, m.touched, m.expires)
+}
+
+func parseSessionMeta(v string) (sessionMeta, bool) {
+	var m sessionMeta
+	if _, err := fmt.Sscanf(v, "%d %d %d", &m.created, &m.touched, &m.expires); err != nil {
+		return sessionMeta{}, false
+	}
+	return m, true
+}
+
+func (s *CacheStore) metaKey(id string) string {
+	return s.keyPrefix + "meta_" + id
+}
+
+func (s *CacheStore) userIndexKey(userID string) string {
+	return s.keyPrefix + "user_" + userID
+}
+
+// newSessionID builds an alphanumeric key for the redis store.
+func newSessionID() string {
+	return strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+}
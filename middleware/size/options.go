@@ -0,0 +1,90 @@
+package limits
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Option for the request size limiter
+type Option func(*config)
+
+// LimitError is the error of a request over a limit.
+type LimitError struct {
+	// Limit is the exceeded limit, in bytes or in parts when Reason is "parts".
+	Limit int64
+	// Reason is "body", "part" or "parts".
+	Reason string
+}
+
+func (e *LimitError) Error() string {
+	switch e.Reason {
+	case reasonPart:
+		return fmt.Sprintf("HTTP request too large: a part is over %d bytes", e.Limit)
+	case reasonParts:
+		return fmt.Sprintf("HTTP request too large: over %d parts", e.Limit)
+	}
+	return "HTTP request too large"
+}
+
+const (
+	reasonBody  = "body"
+	reasonPart  = "part"
+	reasonParts = "parts"
+)
+
+// Renderer writes the response of a request over a limit.
+type Renderer func(c *gin.Context, err *LimitError)
+
+type config struct {
+	limit        int64
+	routes       map[string]int64
+	contentTypes map[string]int64
+	maxPartSize  int64
+	maxParts     int64
+	renderer     Renderer
+}
+
+// WithLimit set the limit of the requests without a route or content type limit, 0 is no limit.
+func WithLimit(limit int64) Option {
+	return func(cfg *config) {
+		cfg.limit = limit
+	}
+}
+
+// WithRouteLimits set the limits of routes, by their full path as in c.FullPath(), e.g. "/users/:id".
+// They take precedence over the content type limits, routes with a limit of 0 are not limited.
+func WithRouteLimits(routes map[string]int64) Option {
+	return func(cfg *config) {
+		cfg.routes = routes
+	}
+}
+
+// WithContentTypeLimits set the limits of content types, e.g. "multipart/form-data", "application/json"
+// or "text/*". Content types with a limit of 0 are not limited.
+func WithContentTypeLimits(contentTypes map[string]int64) Option {
+	return func(cfg *config) {
+		cfg.contentTypes = contentTypes
+	}
+}
+
+// WithMultipartLimits set the max size of every part, i.e. of every uploaded file with its headers, and the
+// max number of parts of multipart bodies, 0 is no limit.
+func WithMultipartLimits(maxPartSize, maxParts int64) Option {
+	return func(cfg *config) {
+		cfg.maxPartSize = maxPartSize
+		cfg.maxParts = maxParts
+	}
+}
+
+// WithRenderer set the renderer of the 413 responses, e.g. to match an API error envelope.
+func WithRenderer(r Renderer) Option {
+	return func(cfg *config) {
+		cfg.renderer = r
+	}
+}
+
+func defaultRenderer(c *gin.Context, err *LimitError) {
+	c.String(http.StatusRequestEntityTooLarge, "request too large")
+}
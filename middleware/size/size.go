@@ -1,9 +1,11 @@
 package limits
 
 import (
-	"fmt"
+	"bytes"
 	"io"
+	"mime"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -12,29 +14,48 @@ type maxBytesReader struct {
 	ctx        *gin.Context
 	rdr        io.ReadCloser
 	remaining  int64
+	limit      int64
+	multipart  *multipartLimiter
+	renderer   Renderer
+	err        *LimitError
 	wasAborted bool
 	sawEOF     bool
 }
 
-func (mbr *maxBytesReader) tooLarge() (n int, err error) {
-	n, err = 0, fmt.Errorf("HTTP request too large")
+func (mbr *maxBytesReader) tooLarge(le *LimitError) (n int, err error) {
+	n, err = 0, le
 
 	if !mbr.wasAborted {
 		mbr.wasAborted = true
-		ctx := mbr.ctx
-		_ = ctx.Error(err)
-		ctx.Header("connection", "close")
-		ctx.String(http.StatusRequestEntityTooLarge, "request too large")
-		ctx.AbortWithStatus(http.StatusRequestEntityTooLarge)
+		mbr.err = le
+		abort(mbr.ctx, le, mbr.renderer)
 	}
 	return
 }
 
+// abort adds err to the context and renders it, the connection is closed as the body is not read.
+func abort(ctx *gin.Context, err *LimitError, renderer Renderer) {
+	_ = ctx.Error(err)
+	ctx.Header("connection", "close")
+	if renderer == nil {
+		renderer = defaultRenderer
+	}
+	renderer(ctx, err)
+	ctx.Abort()
+}
+
 func (mbr *maxBytesReader) Read(p []byte) (n int, err error) {
+	if mbr.wasAborted {
+		return 0, mbr.err
+	}
+	if mbr.limit <= 0 {
+		n, err = mbr.rdr.Read(p)
+		return mbr.scan(p[:n], n, err)
+	}
 	toRead := mbr.remaining
 	if mbr.remaining == 0 {
 		if mbr.sawEOF {
-			return mbr.tooLarge()
+			return mbr.tooLarge(&LimitError{Limit: mbr.limit, Reason: reasonBody})
 		}
 		// The underlying io.Reader may not return (0, io.EOF)
 		// at EOF if the requested size is 0, so read 1 byte
@@ -55,7 +76,7 @@ func (mbr *maxBytesReader) Read(p []byte) (n int, err error) {
 		// If we had zero bytes to read remaining (but hadn't seen EOF)
 		// and we get a byte here, that means we went over our limit.
 		if n > 0 {
-			return mbr.tooLarge()
+			return mbr.tooLarge(&LimitError{Limit: mbr.limit, Reason: reasonBody})
 		}
 		return 0, err
 	}
@@ -63,6 +84,16 @@ func (mbr *maxBytesReader) Read(p []byte) (n int, err error) {
 	if mbr.remaining < 0 {
 		mbr.remaining = 0
 	}
+	return mbr.scan(p[:n], n, err)
+}
+
+// scan applies the multipart limits to the bytes read.
+func (mbr *maxBytesReader) scan(p []byte, n int, err error) (int, error) {
+	if mbr.multipart != nil {
+		if le := mbr.multipart.scan(p); le != nil {
+			return mbr.tooLarge(le)
+		}
+	}
 	return n, err
 }
 
@@ -70,6 +101,60 @@ func (mbr *maxBytesReader) Close() error {
 	return mbr.rdr.Close()
 }
 
+// multipartLimiter follows the boundaries of a multipart body to limit the size and number of its parts.
+type multipartLimiter struct {
+	delimiter   []byte
+	maxPartSize int64
+	maxParts    int64
+	delimiters  int64
+	partSize    int64
+	// tail is the end of the bytes scanned, a delimiter may continue in the next ones
+	tail []byte
+}
+
+func newMultipartLimiter(boundary string, maxPartSize, maxParts int64) *multipartLimiter {
+	return &multipartLimiter{delimiter: []byte("--" + boundary), maxPartSize: maxPartSize, maxParts: maxParts}
+}
+
+func (m *multipartLimiter) scan(p []byte) *LimitError {
+	buf := append(m.tail, p...)
+	// offset of the start of the current part in buf, the tail is part of it
+	start := int64(len(m.tail)) - m.partSize
+	for i := 0; ; {
+		j := bytes.Index(buf[i:], m.delimiter)
+		if j < 0 {
+			break
+		}
+		if err := m.checkPart(int64(i+j) - start); err != nil {
+			return err
+		}
+		i += j + len(m.delimiter)
+		start = int64(i)
+		m.delimiters++
+		// the first delimiter opens the first part, the closing one ends the last part
+		if m.maxParts > 0 && m.delimiters-1 > m.maxParts {
+			return &LimitError{Limit: m.maxParts, Reason: reasonParts}
+		}
+	}
+	m.partSize = int64(len(buf)) - start
+	if err := m.checkPart(m.partSize); err != nil {
+		return err
+	}
+	if keep := len(m.delimiter) - 1; len(buf) > keep {
+		buf = buf[len(buf)-keep:]
+	}
+	m.tail = append(m.tail[:0], buf...)
+	return nil
+}
+
+// checkPart checks the size of the current part, the preamble before the first delimiter is not a part.
+func (m *multipartLimiter) checkPart(size int64) *LimitError {
+	if m.maxPartSize > 0 && m.delimiters > 0 && size > m.maxPartSize {
+		return &LimitError{Limit: m.maxPartSize, Reason: reasonPart}
+	}
+	return nil
+}
+
 // RequestSizeLimiter returns a middleware that limits the size of request
 // When a request is over the limit, the following will happen:
 // * Error will be added to the context
@@ -77,14 +162,58 @@ func (mbr *maxBytesReader) Close() error {
 // * Error 413 will be sent to the client (http.StatusRequestEntityTooLarge)
 // * Current context will be aborted
 func RequestSizeLimiter(limit int64) gin.HandlerFunc {
+	return New(WithLimit(limit))
+}
+
+// New returns a middleware that limits the size of requests like RequestSizeLimiter, with
+// per-route, per-content-type and multipart limits. Requests whose Content-Length is over
+// their limit are rejected before their body is read.
+func New(opts ...Option) gin.HandlerFunc {
+	cfg := &config{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
 	return func(ctx *gin.Context) {
+		mediaType, params, _ := mime.ParseMediaType(ctx.GetHeader("Content-Type"))
+		limit := cfg.limitOf(ctx, mediaType)
+		if limit > 0 && ctx.Request.ContentLength > limit {
+			abort(ctx, &LimitError{Limit: limit, Reason: reasonBody}, cfg.renderer)
+			return
+		}
+		var multipart *multipartLimiter
+		if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" && (cfg.maxPartSize > 0 || cfg.maxParts > 0) {
+			multipart = newMultipartLimiter(params["boundary"], cfg.maxPartSize, cfg.maxParts)
+		}
+		if ctx.Request.Body == nil || ctx.Request.Body == http.NoBody || (limit <= 0 && multipart == nil) {
+			ctx.Next()
+			return
+		}
 		ctx.Request.Body = &maxBytesReader{
 			ctx:        ctx,
 			rdr:        ctx.Request.Body,
 			remaining:  limit,
+			limit:      limit,
+			multipart:  multipart,
+			renderer:   cfg.renderer,
 			wasAborted: false,
 			sawEOF:     false,
 		}
 		ctx.Next()
 	}
 }
+
+// limitOf returns the limit of the request, the one of its route first, then the one of its content type.
+func (cfg *config) limitOf(ctx *gin.Context, mediaType string) int64 {
+	if limit, ok := cfg.routes[ctx.FullPath()]; ok {
+		return limit
+	}
+	if limit, ok := cfg.contentTypes[mediaType]; ok {
+		return limit
+	}
+	if i := strings.IndexByte(mediaType, '/'); i > 0 {
+		if limit, ok := cfg.contentTypes[mediaType[:i]+"/*"]; ok {
+			return limit
+		}
+	}
+	return cfg.limit
+}
@@ -2,10 +2,14 @@ package limits
 
 import (
 	"bytes"
+	"errors"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/gin-gonic/gin"
 )
@@ -39,13 +43,170 @@ func TestRequestSizeLimiterOver(t *testing.T) {
 		c.Request.Body.Close()
 		c.String(http.StatusOK, "OK")
 	})
-	resp := performRequest(http.MethodPost, "/test_large", "big=abc", router)
+	resp := performRequest(http.MethodPost, "/test_large", "big=abcdefghijklmnop", router)
 
 	if resp.Code != http.StatusRequestEntityTooLarge {
 		t.Fatalf("error posting - http status %v", resp.Code)
 	}
 }
 
+func TestRouteAndContentTypeLimits(t *testing.T) {
+	router := gin.New()
+	router.Use(New(
+		WithLimit(100),
+		WithRouteLimits(map[string]int64{"/upload/:name": 1000}),
+		WithContentTypeLimits(map[string]int64{"application/json": 10, "text/*": 20}),
+	))
+	handler := func(c *gin.Context) {
+		if _, err := io.ReadAll(c.Request.Body); err != nil {
+			return
+		}
+		c.String(http.StatusOK, "OK")
+	}
+	router.POST("/upload/:name", handler)
+	router.POST("/data", handler)
+
+	tests := []struct {
+		target, contentType string
+		size                int
+		status              int
+	}{
+		{"/data", "application/json", 10, http.StatusOK},
+		{"/data", "application/json; charset=utf-8", 11, http.StatusRequestEntityTooLarge},
+		{"/data", "text/csv", 20, http.StatusOK},
+		{"/data", "text/plain", 21, http.StatusRequestEntityTooLarge},
+		{"/data", "application/octet-stream", 100, http.StatusOK},
+		{"/data", "application/octet-stream", 101, http.StatusRequestEntityTooLarge},
+		{"/upload/a", "application/json", 1000, http.StatusOK},
+		{"/upload/a", "application/json", 1001, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(strings.Repeat("a", tt.size)))
+		r.Header.Set("Content-Type", tt.contentType)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s %s %d bytes: status %d, want %d", tt.target, tt.contentType, tt.size, w.Code, tt.status)
+		}
+	}
+}
+
+func TestContentLengthRejected(t *testing.T) {
+	router := gin.New()
+	router.Use(RequestSizeLimiter(10))
+	called := false
+	router.POST("/", func(c *gin.Context) {
+		called = true
+	})
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("a", 11)))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusRequestEntityTooLarge || called {
+		t.Fatalf("status %d, handler called %v", w.Code, called)
+	}
+	if w.Header().Get("Connection") != "close" {
+		t.Errorf("connection header %q", w.Header().Get("Connection"))
+	}
+
+	// a body without Content-Length is limited while it is read
+	r = httptest.NewRequest(http.MethodPost, "/", io.NopCloser(strings.NewReader(strings.Repeat("a", 11))))
+	r.ContentLength = -1
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if !called {
+		t.Fatal("handler not called")
+	}
+}
+
+func multipartBody(t *testing.T, parts ...int) (*bytes.Buffer, string) {
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	for i, size := range parts {
+		fw, err := mw.CreateFormFile("file", strings.Repeat("f", i+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(bytes.Repeat([]byte("x"), size))
+	}
+	mw.Close()
+	return body, mw.FormDataContentType()
+}
+
+func TestMultipartLimits(t *testing.T) {
+	router := gin.New()
+	router.Use(New(WithMultipartLimits(1000, 3)))
+	var reason string
+	router.POST("/", func(c *gin.Context) {
+		if _, err := c.MultipartForm(); err != nil {
+			var le *LimitError
+			if errors.As(err, &le) {
+				reason = le.Reason
+			}
+			return
+		}
+		c.String(http.StatusOK, "OK")
+	})
+
+	tests := []struct {
+		parts  []int
+		status int
+		reason string
+	}{
+		{[]int{800, 800, 800}, http.StatusOK, ""},
+		{[]int{10, 1000}, http.StatusRequestEntityTooLarge, reasonPart},
+		{[]int{10, 10, 10, 10}, http.StatusRequestEntityTooLarge, reasonParts},
+		{[]int{100000}, http.StatusRequestEntityTooLarge, reasonPart},
+	}
+	for _, tt := range tests {
+		for _, oneByte := range []bool{false, true} {
+			body, contentType := multipartBody(t, tt.parts...)
+			var rdr io.Reader = body
+			if oneByte {
+				// delimiters are split across reads
+				rdr = iotest.OneByteReader(body)
+			}
+			r := httptest.NewRequest(http.MethodPost, "/", rdr)
+			r.Header.Set("Content-Type", contentType)
+			w := httptest.NewRecorder()
+			reason = ""
+			router.ServeHTTP(w, r)
+			if w.Code != tt.status || reason != tt.reason {
+				t.Errorf("parts %v, one byte reads %v: status %d reason %q, want %d %q", tt.parts, oneByte, w.Code, reason, tt.status, tt.reason)
+			}
+		}
+	}
+}
+
+func TestRenderer(t *testing.T) {
+	router := gin.New()
+	var got *LimitError
+	router.Use(New(
+		WithLimit(10),
+		WithRenderer(func(c *gin.Context, err *LimitError) {
+			got = err
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": gin.H{"code": "too_large", "limit": err.Limit}})
+		}),
+	))
+	router.POST("/", func(c *gin.Context) {
+		io.ReadAll(c.Request.Body)
+	})
+	r := httptest.NewRequest(http.MethodPost, "/", io.NopCloser(strings.NewReader(strings.Repeat("a", 11))))
+	r.ContentLength = -1
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d", w.Code)
+	}
+	if got == nil || got.Limit != 10 || got.Reason != reasonBody {
+		t.Fatalf("error %+v", got)
+	}
+	if body := w.Body.String(); body != `{"error":{"code":"too_large","limit":10}}` {
+		t.Errorf("body %s", body)
+	}
+}
+
 func performRequest(method, target, body string, router *gin.Engine) *httptest.ResponseRecorder {
 	var buf *bytes.Buffer
 	if body != "" {
@@ -17,6 +17,14 @@ func WithTimeout(timeout time.Duration) Option {
 	}
 }
 
+// WithRouteTimeouts set the timeouts of routes, by their full path as in c.FullPath(),
+// e.g. "/users/:id". Routes with a timeout of 0 are not limited.
+func WithRouteTimeouts(routes map[string]time.Duration) Option {
+	return func(t *Timeout) {
+		t.routes = routes
+	}
+}
+
 // WithHandler add gin handler
 func WithHandler(h gin.HandlerFunc) Option {
 	return func(t *Timeout) {
@@ -31,13 +39,26 @@ func WithResponse(h gin.HandlerFunc) Option {
 	}
 }
 
+// AbandonedFunc is called when the handler of route is abandoned at its timeout, and when an
+// abandoned handler returns, with the number of handlers still running after their timeout.
+type AbandonedFunc func(route string, abandoned int64)
+
+// WithAbandoned set the callback of the abandoned handlers, e.g. to export them as a metric.
+func WithAbandoned(fn AbandonedFunc) Option {
+	return func(t *Timeout) {
+		t.abandoned = fn
+	}
+}
+
 func defaultResponse(c *gin.Context) {
 	c.String(http.StatusRequestTimeout, http.StatusText(http.StatusRequestTimeout))
 }
 
 // Timeout struct
 type Timeout struct {
-	timeout  time.Duration
-	handler  gin.HandlerFunc
-	response gin.HandlerFunc
+	timeout   time.Duration
+	handler   gin.HandlerFunc
+	response  gin.HandlerFunc
+	abandoned AbandonedFunc
+	routes    map[string]time.Duration
 }
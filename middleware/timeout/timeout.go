@@ -1,19 +1,101 @@
 package timeout
 
 import (
+	"context"
 	"github.com/donetkit/contrib/utils/buffer"
+	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-var bufPool *buffer.Pool
-
 const (
 	defaultTimeout = 5 * time.Second
 )
 
-// New wraps a handler and aborts the process of the handler if the timeout is reached
+// abandoned is the number of handlers still running after their timeout, of every instance.
+var abandoned int64
+
+// Abandoned returns the number of handlers still running after their timeout. They return
+// once they observe the cancellation of the request context, a growing number means they don't.
+func Abandoned() int64 {
+	return atomic.LoadInt64(&abandoned)
+}
+
+// states of a handler
+const (
+	running int32 = iota
+	finished
+	abandoning
+)
+
+// notify calls the abandoned callback with the number of abandoned handlers.
+func (t *Timeout) notify(route string, n int64) {
+	if t.abandoned != nil {
+		t.abandoned(route, n)
+	}
+}
+
+// duration returns the timeout of the route of c.
+func (t *Timeout) duration(c *gin.Context) time.Duration {
+	if d, ok := t.routes[c.FullPath()]; ok {
+		return d
+	}
+	return t.timeout
+}
+
+// timeoutContext reports the deadline of the timeout until the response is streamed,
+// the timeout no longer applies then.
+type timeoutContext struct {
+	context.Context
+	cancel   context.CancelFunc
+	deadline time.Time
+	writer   *Writer
+	expired  int32
+}
+
+// newContext returns the request context of a handler with the timeout d.
+func newContext(req context.Context, d time.Duration, tw *Writer) *timeoutContext {
+	ctx, cancel := context.WithCancel(req)
+	return &timeoutContext{Context: ctx, cancel: cancel, deadline: time.Now().Add(d), writer: tw}
+}
+
+func (c *timeoutContext) Deadline() (time.Time, bool) {
+	parent, ok := c.Context.Deadline()
+	if c.writer.isCommitted() || (ok && parent.Before(c.deadline)) {
+		return parent, ok
+	}
+	return c.deadline, true
+}
+
+func (c *timeoutContext) Err() error {
+	err := c.Context.Err()
+	if err != nil && atomic.LoadInt32(&c.expired) == 1 {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// expire cancels the context at its deadline.
+func (c *timeoutContext) expire() {
+	atomic.StoreInt32(&c.expired, 1)
+	c.cancel()
+}
+
+// New wraps a handler and aborts the process of the handler if the timeout is reached.
+// The request context of the handler is cancelled at the timeout, so the calls it makes with it
+// are cancelled too. The handler runs with a copy of the gin context, see gin.Context.Copy, which
+// it may keep using after the timeout, its writes are then discarded. The copy has no route and
+// no chain, FullPath is empty and Next does nothing. The rest of the chain runs after the handler,
+// unless it answered with an error status, e.g. with c.AbortWithStatus(401).
+//
+// Without WithHandler, the timeout applies to the remaining handlers of the chain instead. They
+// run on the request goroutine, the timeout response is written when the deadline is reached
+// and they are expected to return once the request context is cancelled.
+//
+// The response is buffered until it is flushed, the timeout applies until then only, so
+// streams are not cut.
 func New(opts ...Option) gin.HandlerFunc {
 	t := &Timeout{
 		timeout:  defaultTimeout,
@@ -31,64 +113,191 @@ func New(opts ...Option) gin.HandlerFunc {
 		opt(t)
 	}
 
-	if t.timeout <= 0 {
+	if t.timeout <= 0 && len(t.routes) == 0 {
+		if t.handler == nil {
+			return func(c *gin.Context) {}
+		}
 		return t.handler
 	}
 
-	bufPool = &buffer.Pool{}
+	bufPool := &buffer.Pool{}
 
+	if t.handler == nil {
+		return func(c *gin.Context) {
+			if d := t.duration(c); d > 0 {
+				t.serveChain(c, d, bufPool)
+			}
+		}
+	}
 	return func(c *gin.Context) {
-		finish := make(chan struct{}, 1)
-		panicChan := make(chan interface{}, 1)
+		if d := t.duration(c); d > 0 {
+			t.serveHandler(c, d, bufPool)
+			return
+		}
+		t.handler(c)
+	}
+}
 
-		w := c.Writer
-		buffer := bufPool.Get()
-		tw := NewWriter(w, buffer)
-		c.Writer = tw
-		buffer.Reset()
-
-		go func() {
-			defer func() {
-				if p := recover(); p != nil {
-					panicChan <- p
-				}
-			}()
-			t.handler(c)
-			finish <- struct{}{}
-		}()
+// copyContext returns a copy of c with req and w, see gin.Context.Copy.
+func copyContext(c *gin.Context, req *http.Request, w gin.ResponseWriter) *gin.Context {
+	cp := c.Copy()
+	cp.Request = req
+	cp.Writer = w
+	return cp
+}
+
+// serveHandler runs the handler in a goroutine, with a copy of c.
+func (t *Timeout) serveHandler(c *gin.Context, d time.Duration, bufPool *buffer.Pool) {
+	finish := make(chan bool, 1)
+	panicChan := make(chan interface{}, 1)
 
-		select {
-		case p := <-panicChan:
-			tw.FreeBuffer()
-			c.Writer = w
-			panic(p)
-
-		case <-finish:
-			c.Next()
-			tw.mu.Lock()
-			defer tw.mu.Unlock()
-			dst := tw.ResponseWriter.Header()
-			for k, vv := range tw.Header() {
-				dst[k] = vv
+	w := c.Writer
+	buffer := bufPool.Get()
+	tw := NewWriter(w, buffer)
+	buffer.Reset()
+
+	req := c.Request
+	ctx := newContext(req.Context(), d, tw)
+	defer ctx.cancel()
+	// the handler gets its own context, gin recycles c once the middleware returns
+	hc := copyContext(c, req.WithContext(ctx), tw)
+	route := c.FullPath()
+	state := running
+
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				panicChan <- p
+			}
+		}()
+		defer func() {
+			if !atomic.CompareAndSwapInt32(&state, running, finished) {
+				t.notify(route, atomic.AddInt64(&abandoned, -1))
 			}
-			tw.ResponseWriter.WriteHeader(tw.code)
-			if _, err := tw.ResponseWriter.Write(buffer.Bytes()); err != nil {
-				panic(err)
+		}()
+		t.handler(hc)
+		// the timer may not have fired yet
+		finish <- !time.Now().Before(ctx.deadline)
+	}()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	var timedOut bool
+	select {
+	case p := <-panicChan:
+		tw.mu.Lock()
+		tw.FreeBuffer()
+		tw.mu.Unlock()
+		panic(p)
+
+	case late := <-finish:
+		// a handler returning after the deadline, e.g. because of it, timed out too
+		timedOut = late && tw.expire()
+
+	case <-timer.C:
+		if !tw.expire() {
+			// the response is streamed, wait for the handler
+			select {
+			case p := <-panicChan:
+				panic(p)
+			case <-finish:
 			}
-			tw.FreeBuffer()
-			bufPool.Put(buffer)
-
-		case <-time.After(t.timeout):
-			c.Abort()
-			tw.mu.Lock()
-			defer tw.mu.Unlock()
-			tw.timeout = true
-			tw.FreeBuffer()
-			bufPool.Put(buffer)
-
-			c.Writer = w
-			t.response(c)
-			c.Writer = tw
+			break
+		}
+		timedOut = true
+		ctx.expire()
+		// counted before the handler may return and uncount it
+		n := atomic.AddInt64(&abandoned, 1)
+		if atomic.CompareAndSwapInt32(&state, running, abandoning) {
+			t.notify(route, n)
+		} else {
+			atomic.AddInt64(&abandoned, -1)
+		}
+	}
+
+	if timedOut {
+		c.Abort()
+		tw.mu.Lock()
+		tw.FreeBuffer()
+		tw.mu.Unlock()
+		bufPool.Put(buffer)
+
+		t.response(c)
+		return
+	}
+
+	// the handler is done with its context
+	for k, v := range hc.Keys {
+		c.Set(k, v)
+	}
+	c.Errors = append(c.Errors, hc.Errors...)
+	if tw.Written() && tw.Status() >= http.StatusBadRequest {
+		// e.g. c.AbortWithStatus(401), the copy doesn't tell whether the handler aborted
+		c.Abort()
+	} else {
+		c.Writer = tw
+		c.Next()
+		c.Writer = w
+	}
+	if err := tw.flushBuffer(); err != nil {
+		panic(err)
+	}
+	tw.mu.Lock()
+	tw.FreeBuffer()
+	tw.mu.Unlock()
+	bufPool.Put(buffer)
+}
+
+// serveChain runs the remaining handlers on the request goroutine, the timeout response is
+// written by a timer.
+func (t *Timeout) serveChain(c *gin.Context, d time.Duration, bufPool *buffer.Pool) {
+	w := c.Writer
+	buffer := bufPool.Get()
+	tw := NewWriter(w, buffer)
+	buffer.Reset()
+
+	req := c.Request
+	ctx := newContext(req.Context(), d, tw)
+	defer ctx.cancel()
+	route := c.FullPath()
+	// the timeout response is rendered with a copy of c, c is in use by the chain
+	rc := copyContext(c, req, w)
+	fired := make(chan struct{})
+	timer := time.AfterFunc(d, func() {
+		defer close(fired)
+		if !tw.expire() {
+			// the response is streamed
+			return
+		}
+		ctx.expire()
+		t.notify(route, atomic.AddInt64(&abandoned, 1))
+		t.response(rc)
+		// the chain is still running, the response would only be sent once it returns
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	})
+
+	c.Request = req.WithContext(ctx)
+	c.Writer = tw
+	defer func() {
+		// also when the chain panics, so the timer doesn't use the writer of another request
+		if !timer.Stop() {
+			<-fired
+		}
+		c.Request = req
+		c.Writer = w
+		if tw.timeout {
+			t.notify(route, atomic.AddInt64(&abandoned, -1))
 		}
+	}()
+	c.Next()
+
+	if err := tw.flushBuffer(); err != nil {
+		panic(err)
+	}
+	if tw.timeout {
+		c.Abort()
 	}
+	bufPool.Put(buffer)
 }
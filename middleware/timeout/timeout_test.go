@@ -100,3 +100,204 @@ func TestPanic(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
 	assert.Equal(t, "", w.Body.String())
 }
+
+func TestContextDeadline(t *testing.T) {
+	done := make(chan error, 1)
+	r := gin.New()
+	r.GET("/", New(WithTimeout(10*time.Millisecond), WithHandler(func(c *gin.Context) {
+		_, ok := c.Request.Context().Deadline()
+		assert.True(t, ok)
+		<-c.Request.Context().Done()
+		// the gin context is still usable after the timeout
+		c.Set("late", true)
+		c.String(http.StatusOK, "late")
+		done <- c.Request.Context().Err()
+	})))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusRequestTimeout, w.Code)
+	assert.Equal(t, context.DeadlineExceeded, <-done)
+}
+
+func TestAbandoned(t *testing.T) {
+	release := make(chan struct{})
+	returned := make(chan int64, 1)
+	var counts []int64
+	r := gin.New()
+	r.GET("/slow", New(
+		WithTimeout(time.Millisecond),
+		WithHandler(func(c *gin.Context) {
+			// ignores the cancellation
+			<-release
+		}),
+		WithAbandoned(func(route string, abandoned int64) {
+			assert.Equal(t, "/slow", route)
+			counts = append(counts, abandoned)
+			if len(counts) == 2 {
+				returned <- abandoned
+			}
+		}),
+	))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/slow", nil)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusRequestTimeout, w.Code)
+	assert.Equal(t, int64(1), Abandoned())
+	close(release)
+	assert.Equal(t, int64(0), <-returned)
+	assert.Equal(t, int64(0), Abandoned())
+}
+
+func TestChain(t *testing.T) {
+	r := gin.New()
+	r.Use(New(WithTimeout(10 * time.Millisecond)))
+	r.GET("/slow", func(c *gin.Context) {
+		c.Header("X-Partial", "1")
+		c.String(http.StatusOK, "partial")
+		<-c.Request.Context().Done()
+	})
+	r.GET("/fast", func(c *gin.Context) {
+		c.Next()
+	}, func(c *gin.Context) {
+		c.String(http.StatusCreated, "done")
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/slow", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestTimeout, w.Code)
+	assert.Equal(t, http.StatusText(http.StatusRequestTimeout), w.Body.String())
+	assert.Empty(t, w.Header().Get("X-Partial"))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequestWithContext(context.Background(), "GET", "/fast", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "done", w.Body.String())
+}
+
+func TestChainTimeoutFlushed(t *testing.T) {
+	release := make(chan struct{})
+	r := gin.New()
+	r.Use(New(WithTimeout(10 * time.Millisecond)))
+	r.GET("/stuck", func(c *gin.Context) {
+		// ignores the cancellation of the request context
+		<-release
+	})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	defer close(release)
+
+	client := &http.Client{Timeout: time.Second}
+	res, err := client.Get(srv.URL + "/stuck")
+	if assert.NoError(t, err, "the timeout response must be sent while the chain is stuck") {
+		res.Body.Close()
+		assert.Equal(t, http.StatusRequestTimeout, res.StatusCode)
+	}
+}
+
+func TestStreaming(t *testing.T) {
+	r := gin.New()
+	r.Use(New(WithTimeout(10 * time.Millisecond)))
+	r.GET("/events", func(c *gin.Context) {
+		c.Header("Content-Type", "text/event-stream")
+		c.String(http.StatusOK, "data: 1\n\n")
+		c.Writer.Flush()
+		time.Sleep(20 * time.Millisecond)
+		assert.NoError(t, c.Request.Context().Err(), "the timeout no longer applies once streamed")
+		_, ok := c.Request.Context().Deadline()
+		assert.False(t, ok)
+		c.String(http.StatusOK, "data: 2\n\n")
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/events", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, w.Flushed)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, "data: 1\n\ndata: 2\n\n", w.Body.String())
+}
+
+func TestRouteTimeouts(t *testing.T) {
+	r := gin.New()
+	r.Use(New(WithTimeout(time.Second), WithRouteTimeouts(map[string]time.Duration{
+		"/reports/:id": 5 * time.Millisecond,
+		"/export":      0,
+	})))
+	slow := func(c *gin.Context) {
+		select {
+		case <-c.Request.Context().Done():
+		case <-time.After(20 * time.Millisecond):
+		}
+		c.String(http.StatusOK, "ok")
+	}
+	r.GET("/reports/:id", slow)
+	r.GET("/export", slow)
+	r.GET("/users/:id", slow)
+
+	for path, code := range map[string]int{
+		"/reports/1": http.StatusRequestTimeout,
+		"/export":    http.StatusOK,
+		"/users/1":   http.StatusOK,
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", path, nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, code, w.Code, path)
+	}
+}
+
+func TestHandlerThenChain(t *testing.T) {
+	r := gin.New()
+	r.GET("/", New(WithTimeout(time.Second), WithHandler(func(c *gin.Context) {
+		c.String(http.StatusOK, "handler ")
+	})), func(c *gin.Context) {
+		c.String(http.StatusOK, "chain")
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, "handler chain", w.Body.String())
+}
+
+func TestHandlerAbort(t *testing.T) {
+	var id string
+	downstream := 0
+	r := gin.New()
+	r.GET("/users/:id", New(WithTimeout(time.Second), WithHandler(func(c *gin.Context) {
+		id = c.Param("id")
+		c.AbortWithStatus(http.StatusUnauthorized)
+	})), func(c *gin.Context) {
+		downstream++
+		c.String(http.StatusOK, "secret")
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", "/users/42", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, 0, downstream)
+	assert.Equal(t, "42", id)
+
+	// a handler running the chain itself doesn't run it twice
+	r = gin.New()
+	r.GET("/", New(WithTimeout(time.Second), WithHandler(func(c *gin.Context) {
+		c.Next()
+	})), func(c *gin.Context) {
+		downstream++
+		c.String(http.StatusOK, "chain")
+	})
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequestWithContext(context.Background(), "GET", "/", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, "chain", w.Body.String())
+	assert.Equal(t, 1, downstream)
+}
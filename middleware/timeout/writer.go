@@ -1,15 +1,19 @@
 package timeout
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"sync"
 
 	"github.com/gin-gonic/gin"
 )
 
-// Writer is a writer with memory buffer
+// Writer is a writer with memory buffer. The buffer is written to the response on the first
+// flush, the writes then pass through and the timeout no longer applies.
 type Writer struct {
 	gin.ResponseWriter
 	body         *bytes.Buffer
@@ -17,6 +21,7 @@ type Writer struct {
 	mu           sync.Mutex
 	timeout      bool
 	wroteHeaders bool
+	committed    bool
 	code         int
 }
 
@@ -27,26 +32,31 @@ func NewWriter(w gin.ResponseWriter, buf *bytes.Buffer) *Writer {
 
 // Write will write data to response body
 func (w *Writer) Write(data []byte) (int, error) {
-	if w.timeout || w.body == nil {
-		return 0, nil
-	}
-
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	if w.timeout || w.body == nil {
+		return 0, nil
+	}
+	if w.committed {
+		return w.ResponseWriter.Write(data)
+	}
+	if !w.wroteHeaders {
+		w.writeHeader(http.StatusOK)
+	}
 	return w.body.Write(data)
 }
 
 // WriteHeader will write http status code
 func (w *Writer) WriteHeader(code int) {
 	checkWriteHeaderCode(code)
-	if w.timeout || w.wroteHeaders {
-		return
-	}
 
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	if w.timeout || w.wroteHeaders {
+		return
+	}
 	w.writeHeader(code)
 }
 
@@ -55,8 +65,17 @@ func (w *Writer) writeHeader(code int) {
 	w.code = code
 }
 
+// WriteHeaderNow does nothing, the header is written with the buffered body.
+func (w *Writer) WriteHeaderNow() {}
+
 // Header will get response headers
 func (w *Writer) Header() http.Header {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.committed {
+		return w.ResponseWriter.Header()
+	}
 	return w.headers
 }
 
@@ -65,6 +84,117 @@ func (w *Writer) WriteString(s string) (int, error) {
 	return w.Write([]byte(s))
 }
 
+// Status returns the buffered status code, the response writer may be in use by another request
+// once the handler timed out.
+func (w *Writer) Status() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.committed && !w.timeout {
+		return w.ResponseWriter.Status()
+	}
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
+
+// Size returns the size of the written body, -1 when nothing was written.
+func (w *Writer) Size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.committed && !w.timeout {
+		return w.ResponseWriter.Size()
+	}
+	if w.body == nil || !w.wroteHeaders {
+		return -1
+	}
+	return w.body.Len()
+}
+
+// Written reports whether the handler wrote the status code.
+func (w *Writer) Written() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.wroteHeaders || w.committed
+}
+
+// Flush writes the buffered response and flushes it, the response is streamed from then on.
+func (w *Writer) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timeout || w.body == nil {
+		return
+	}
+	if !w.committed {
+		if err := w.commit(); err != nil {
+			return
+		}
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Hijack lets the caller take over the connection, the timeout no longer applies.
+func (w *Writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timeout {
+		return nil, nil, errors.New("timeout: the handler timed out")
+	}
+	w.committed = true
+	return w.ResponseWriter.Hijack()
+}
+
+// commit writes the buffered header and body to the response.
+func (w *Writer) commit() error {
+	w.committed = true
+	dst := w.ResponseWriter.Header()
+	for k, vv := range w.headers {
+		dst[k] = vv
+	}
+	if w.code != 0 {
+		w.ResponseWriter.WriteHeader(w.code)
+	}
+	_, err := w.ResponseWriter.Write(w.body.Bytes())
+	w.body.Reset()
+	return err
+}
+
+// flushBuffer writes the buffered response once the handler returned in time.
+func (w *Writer) flushBuffer() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.committed || w.timeout {
+		return nil
+	}
+	return w.commit()
+}
+
+// expire discards the writes from now on, unless the response is already streamed.
+// It reports whether the timeout applies.
+func (w *Writer) expire() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.committed {
+		return false
+	}
+	w.timeout = true
+	return true
+}
+
+func (w *Writer) isCommitted() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.committed
+}
+
 // FreeBuffer will release buffer pointer
 func (w *Writer) FreeBuffer() {
 	// if not reset body,old bytes will put in bufPool